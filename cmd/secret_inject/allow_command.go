@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// allowListName is the file, next to the global config, that lists the
+// project configs that may be used without --config.
+const allowListName = ".secret_inject_allowed.json"
+
+// errNotAllowed is returned by checkAllowed for a project config that was
+// never allowed or has changed since.
+var errNotAllowed = errors.New("not allowed")
+
+// runAllowCommand allows a project config as it is now: the given file, or
+// the one discovered from the working directory. It returns the exit code
+// for the process.
+func runAllowCommand(argv []string) int {
+	fs := flag.NewFlagSet("allow", flag.ContinueOnError)
+	if err := fs.Parse(argv); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		fmt.Fprintln(stderr, "Error:", err)
+		return 1
+	}
+	if fs.NArg() > 1 {
+		fmt.Fprintln(stderr, "Error: usage: secret_inject allow [config]")
+		return 1
+	}
+
+	configFile := fs.Arg(0)
+	if configFile == "" {
+		dir, err := os.Getwd()
+		if err != nil {
+			fmt.Fprintln(stderr, "Error:", err)
+			return 1
+		}
+		if configFile = findProjectConfig(dir); configFile == "" {
+			fmt.Fprintln(stderr, "Error: no", projectConfigName, "found in this directory or its parents")
+			return 1
+		}
+	}
+
+	allowed, err := allowConfig(configFile)
+	if err != nil {
+		fmt.Fprintln(stderr, "Error:", err)
+		return 1
+	}
+	fmt.Printf("Allowed %s\n", allowed)
+	return 0
+}
+
+// checkAllowed returns an error wrapping errNotAllowed unless the project
+// config was allowed with its current content. A config decides which
+// commands run and where credentials are sent, so one that is merely found
+// in a parent directory is not used before the user has reviewed it.
+func checkAllowed(configFile string) error {
+	allowList, err := readAllowList()
+	if err != nil {
+		return err
+	}
+	hash, err := fileHash(configFile)
+	if err != nil {
+		return err
+	}
+	if allowList[configFile] != hash {
+		return fmt.Errorf("%s is %w; review it and run 'secret_inject allow' to use it", configFile, errNotAllowed)
+	}
+	return nil
+}
+
+// allowListFile returns the path of the allow-list.
+func allowListFile() string {
+	return filepath.Join(filepath.Dir(defaultFile), allowListName)
+}
+
+// readAllowList returns the allowed project configs: each absolute path
+// mapped to the SHA-256 hash of the content that was allowed.
+func readAllowList() (map[string]string, error) {
+	allowList := make(map[string]string)
+	content, err := os.ReadFile(allowListFile())
+	if os.IsNotExist(err) {
+		return allowList, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &allowList); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", allowListFile(), err)
+	}
+	return allowList, nil
+}
+
+// allowConfig records the current content of configFile in the allow-list
+// and returns the absolute path it was recorded under.
+func allowConfig(configFile string) (string, error) {
+	configFile, err := filepath.Abs(configFile)
+	if err != nil {
+		return "", err
+	}
+	hash, err := fileHash(configFile)
+	if err != nil {
+		return "", err
+	}
+	allowList, err := readAllowList()
+	if err != nil {
+		return "", err
+	}
+	allowList[configFile] = hash
+
+	content, err := json.MarshalIndent(allowList, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(allowListFile()), 0o700); err != nil {
+		return "", err
+	}
+	return configFile, os.WriteFile(allowListFile(), append(content, '\n'), 0o600)
+}
+
+func fileHash(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(content)
+	return hex.EncodeToString(hash[:]), nil
+}
@@ -4,10 +4,16 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
+
+	"github.com/napisani/secret_inject/internal/config"
+	"github.com/napisani/secret_inject/internal/schema"
+	"github.com/napisani/secret_inject/internal/source"
 )
 
 const configTemplate = `{
@@ -40,9 +46,78 @@ const configTemplate = `{
 }
 `
 
+const yamlConfigTemplate = `# secret_inject config. Every value below is a reference to a secret,
+# never the secret itself, so this file is safe to commit.
+
+# Sources listed here are fetched in order, and each one can use the
+# secrets of the sources before it (e.g. a 1Password service account token
+# stored in Doppler). Sources not listed are fetched afterwards.
+source_sequence: [doppler, onepassword, bitwarden]
+
+sources:
+  doppler:
+    project: my-project
+    env: dev
+
+  onepassword:
+    secrets:
+      # op://<vault>/<item>/<field>
+      API_TOKEN: op://Production/API/token
+      DB_USER: op://Production/Database/username
+
+  bitwarden:
+    secrets:
+      # A secret ID, or a key looked up within a project
+      DB_PASSWORD: 382580ab-1368-4e85-bfa3-b02e01400c9f
+      API_TOKEN:
+        key: api-token
+        project_id: e325ea69-a3ab-4dff-836f-b02e013fe530
+
+storage:
+  # keyring, file (plaintext, development only) or encrypted-file
+  type: keyring
+  allowed_backends: [keychain, secret-service, wincred]
+`
+
+const tomlConfigTemplate = `# secret_inject config. Every value below is a reference to a secret,
+# never the secret itself, so this file is safe to commit.
+
+# Sources listed here are fetched in order, and each one can use the
+# secrets of the sources before it (e.g. a 1Password service account token
+# stored in Doppler). Sources not listed are fetched afterwards.
+source_sequence = ["doppler", "onepassword", "bitwarden"]
+
+[sources.doppler]
+project = "my-project"
+env = "dev"
+
+[sources.onepassword.secrets]
+# op://<vault>/<item>/<field>
+API_TOKEN = "op://Production/API/token"
+DB_USER = "op://Production/Database/username"
+
+[sources.bitwarden.secrets]
+# A secret ID, or a key looked up within a project
+DB_PASSWORD = "382580ab-1368-4e85-bfa3-b02e01400c9f"
+API_TOKEN = { key = "api-token", project_id = "e325ea69-a3ab-4dff-836f-b02e013fe530" }
+
+[storage]
+# keyring, file (plaintext, development only) or encrypted-file
+type = "keyring"
+allowed_backends = ["keychain", "secret-service", "wincred"]
+`
+
+// configTemplates holds the template written by config init for each
+// format.
+var configTemplates = map[string]string{
+	config.FormatJSON: configTemplate,
+	config.FormatYAML: yamlConfigTemplate,
+	config.FormatTOML: tomlConfigTemplate,
+}
+
 func runConfigCommand(args []string) error {
 	if len(args) == 0 {
-		return errors.New("usage: secret_inject config <init|edit> [flags]")
+		return errors.New("usage: secret_inject config <init|edit|validate|schema> [flags]")
 	}
 
 	switch args[0] {
@@ -50,7 +125,8 @@ func runConfigCommand(args []string) error {
 		fs := flag.NewFlagSet("config init", flag.ContinueOnError)
 		discard := strings.Builder{}
 		fs.SetOutput(&discard)
-		configPath := fs.String("config", defaultFile, "Config file path")
+		configPath := fs.String("config", "", "Config file path (default: "+defaultFile+" with the extension of --format)")
+		format := fs.String("format", "", "Config format: "+strings.Join(config.Formats, ", ")+" (default: from the --config extension, or json)")
 		force := fs.Bool("force", false, "Overwrite existing config file")
 		if err := fs.Parse(args[1:]); err != nil {
 			if err == flag.ErrHelp {
@@ -58,16 +134,24 @@ func runConfigCommand(args []string) error {
 			}
 			return err
 		}
-		if err := initConfigFile(*configPath, *force); err != nil {
+		path := *configPath
+		if path == "" {
+			extension := config.Extensions[*format]
+			if extension == "" {
+				extension = config.Extensions[config.FormatJSON]
+			}
+			path = strings.TrimSuffix(defaultFile, filepath.Ext(defaultFile)) + extension
+		}
+		if err := initConfigFile(path, *format, *force); err != nil {
 			return err
 		}
-		fmt.Printf("Config written to %s\n", *configPath)
+		fmt.Printf("Config written to %s\n", path)
 		return nil
 	case "edit":
 		fs := flag.NewFlagSet("config edit", flag.ContinueOnError)
 		discard := strings.Builder{}
 		fs.SetOutput(&discard)
-		configPath := fs.String("config", defaultFile, "Config file path")
+		configPath := fs.String("config", globalConfigFile(), "Config file path")
 		editorFlag := fs.String("editor", "", "Editor override (defaults to $EDITOR or vi)")
 		if err := fs.Parse(args[1:]); err != nil {
 			if err == flag.ErrHelp {
@@ -76,16 +160,141 @@ func runConfigCommand(args []string) error {
 			return err
 		}
 		return editConfigFile(*configPath, *editorFlag)
+	case "validate":
+		var validateArgs Args
+		fs := flag.NewFlagSet("config validate", flag.ContinueOnError)
+		discard := strings.Builder{}
+		fs.SetOutput(&discard)
+		fs.StringVar(&validateArgs.ConfigFile, "config", "", configFlagUsage)
+		fs.StringVar(&validateArgs.Profile, "profile", "", profileFlagUsage)
+		withGlobal := fs.Bool("with-global", false, "Layer each file given as an argument on the global config")
+		if err := fs.Parse(args[1:]); err != nil {
+			if err == flag.ErrHelp {
+				return nil
+			}
+			return err
+		}
+
+		problems := 0
+		for _, files := range validateTargets(validateArgs, fs.Args(), *withGlobal) {
+			problems += validateConfig(os.Stdout, files, selectedProfile(validateArgs), fs.NArg() == 0)
+		}
+		if problems == 1 {
+			return errors.New("found 1 problem")
+		} else if problems > 0 {
+			return fmt.Errorf("found %d problems", problems)
+		}
+		return nil
+	case "schema":
+		// The published schema/secret_inject.schema.json is this output.
+		document, err := config.JSONSchema()
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(document)
+		return err
 	default:
 		return fmt.Errorf("unknown config subcommand %q", args[0])
 	}
 }
 
-func initConfigFile(path string, force bool) error {
+// validateTargets returns the configs config validate checks, each as the
+// files it is layered from. Files given as arguments, such as those
+// pre-commit passes, are checked on their own unless withGlobal layers them
+// on the global config the way discovery would; without any, the config
+// args selects is checked.
+func validateTargets(args Args, files []string, withGlobal bool) [][]string {
+	if len(files) == 0 {
+		return [][]string{configFiles(args)}
+	}
+	targets := make([][]string, 0, len(files))
+	for _, file := range files {
+		if withGlobal {
+			targets = append(targets, projectConfigFiles(file))
+		} else {
+			targets = append(targets, []string{file})
+		}
+	}
+	return targets
+}
+
+// validateConfig checks the config layered from files with profile applied:
+// its schema and whether every source initializes, which includes finding
+// its CLI. Nothing is fetched. It writes one line per problem to w, or a
+// confirmation if there is none, and returns the number of problems. A
+// project config may leave storage to each user's global config, so a
+// missing storage is only a problem with requireStorage.
+func validateConfig(w io.Writer, files []string, profile string, requireStorage bool) int {
+	name := strings.Join(files, " + ")
+	report := func(problems []string) int {
+		for _, problem := range problems {
+			fmt.Fprintf(w, "%s: %s\n", name, problem)
+		}
+		if len(problems) == 0 {
+			fmt.Fprintf(w, "%s: valid\n", name)
+		}
+		return len(problems)
+	}
+
+	cfg, err := config.ReadLayered(files...)
+	if err != nil {
+		return report([]string{err.Error()})
+	}
+	if err := cfg.ApplyProfile(profile); err != nil {
+		return report([]string{err.Error()})
+	}
+
+	var problems []string
+	var invalid []string
+	if err := cfg.Validate(); err != nil {
+		var errs schema.Errors
+		if !errors.As(err, &errs) {
+			return report([]string{err.Error()})
+		}
+		for _, problem := range errs {
+			if problem.Path == "$.storage" && cfg.Storage == nil && !requireStorage {
+				continue
+			}
+			problems = append(problems, problem.Error())
+			invalid = append(invalid, problem.Path)
+		}
+	}
+
+	// A source with schema errors would only fail to initialize for the
+	// same reasons.
+	for _, status := range source.Statuses(buildFullConfig(cfg)) {
+		path := schema.Join("$.sources", status.Name)
+		if status.Err != nil && !slices.ContainsFunc(invalid, func(p string) bool { return within(p, path) }) {
+			problems = append(problems, path+": "+status.Err.Error())
+		}
+	}
+	return report(problems)
+}
+
+// within reports whether the JSON path p is path or lies below it.
+func within(p, path string) bool {
+	rest, ok := strings.CutPrefix(p, path)
+	return ok && (rest == "" || rest[0] == '.' || rest[0] == '[')
+}
+
+// initConfigFile writes the config template in format to path. Without a
+// format, the one implied by the path's extension is used, or JSON.
+func initConfigFile(path string, format string, force bool) error {
 	if path == "" {
 		return errors.New("config path cannot be empty")
 	}
 
+	if format == "" {
+		format = config.FormatForPath(path)
+	}
+	if format == "" {
+		format = config.FormatJSON
+	}
+	template, ok := configTemplates[format]
+	if !ok {
+		return fmt.Errorf("unknown config format %q (supported: %s)", format, strings.Join(config.Formats, ", "))
+	}
+
 	if !force {
 		if _, err := os.Stat(path); err == nil {
 			return fmt.Errorf("config file %s already exists (use --force to overwrite)", path)
@@ -99,7 +308,7 @@ func initConfigFile(path string, force bool) error {
 		return fmt.Errorf("creating config directory: %w", err)
 	}
 
-	if err := os.WriteFile(path, []byte(configTemplate), 0o600); err != nil {
+	if err := os.WriteFile(path, []byte(template), 0o600); err != nil {
 		return fmt.Errorf("writing config template: %w", err)
 	}
 
@@ -5,9 +5,12 @@ import (
 	"errors"
 	"os"
 	"path/filepath"
+	"reflect"
 	"runtime"
 	"strings"
 	"testing"
+
+	"github.com/napisani/secret_inject/internal/config"
 )
 
 func TestConfigTemplateIsValidJSON(t *testing.T) {
@@ -62,7 +65,7 @@ func TestInitConfigFileCreatesTemplate(t *testing.T) {
 	dir := t.TempDir()
 	cfgPath := filepath.Join(dir, "config.json")
 
-	if err := initConfigFile(cfgPath, false); err != nil {
+	if err := initConfigFile(cfgPath, "", false); err != nil {
 		t.Fatalf("initConfigFile failed: %v", err)
 	}
 
@@ -87,11 +90,11 @@ func TestInitConfigFileCreatesTemplate(t *testing.T) {
 		}
 	}
 
-	if err := initConfigFile(cfgPath, false); err == nil {
+	if err := initConfigFile(cfgPath, "", false); err == nil {
 		t.Fatalf("expected error when file already exists without force")
 	}
 
-	if err := initConfigFile(cfgPath, true); err != nil {
+	if err := initConfigFile(cfgPath, "", true); err != nil {
 		t.Fatalf("expected force overwrite to succeed: %v", err)
 	}
 }
@@ -141,3 +144,138 @@ func TestEditConfigFileRequiresExistingConfig(t *testing.T) {
 		t.Fatalf("expected missing file error, got %v", err)
 	}
 }
+
+func TestConfigTemplatesDescribeTheSameConfig(t *testing.T) {
+	dir := t.TempDir()
+	var want *config.Config
+	for _, format := range config.Formats {
+		path := filepath.Join(dir, "config"+config.Extensions[format])
+		if err := initConfigFile(path, "", false); err != nil {
+			t.Fatalf("initConfigFile(%s) failed: %v", format, err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != configTemplates[format] {
+			t.Fatalf("expected the %s template in %s", format, path)
+		}
+
+		cfg, err := config.ReadConfig(path)
+		if err != nil {
+			t.Fatalf("reading %s template: %v", format, err)
+		}
+		if err := cfg.Validate(); err != nil {
+			t.Fatalf("%s template is invalid: %v", format, err)
+		}
+		if want == nil {
+			want = cfg
+			continue
+		}
+		if cfg.SourcesFingerprint() != want.SourcesFingerprint() || !reflect.DeepEqual(cfg.Storage, want.Storage) {
+			t.Errorf("%s template differs from the JSON one", format)
+		}
+	}
+
+	if err := initConfigFile(filepath.Join(dir, "config.ini"), "ini", false); err == nil {
+		t.Errorf("expected an error for an unknown format")
+	}
+}
+
+func TestFindProjectConfigFindsOtherFormats(t *testing.T) {
+	root := t.TempDir()
+	project := filepath.Join(root, ".secret_inject.yml")
+	if err := os.WriteFile(project, []byte("sources: {}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	sub := filepath.Join(root, "src")
+	if err := os.Mkdir(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if found := findProjectConfig(sub); found != project {
+		t.Fatalf("expected %s, got %q", project, found)
+	}
+}
+
+func TestValidateConfigReportsEveryProblem(t *testing.T) {
+	// Only doppler is installed.
+	withFakeCLI(t, "doppler", respond(""))
+	withDefaultFile(t, filepath.Join(t.TempDir(), "missing.json"))
+
+	project := filepath.Join(t.TempDir(), projectConfigName)
+	content := `{
+  "sources": {
+    "doppler": {"project": "app", "env": "dev"},
+    "vault": {"secrets": {"DB_PASSWORD": "secret/data/db#password"}},
+    "aws": {"secrets": {"API_KEY": "api#"}, "regoin": "us-east-1"}
+  }
+}`
+	if err := os.WriteFile(project, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	if problems := validateConfig(&out, []string{project}, "", false); problems != 3 {
+		t.Errorf("expected 3 problems, got %d:\n%s", problems, out.String())
+	}
+	want := []string{
+		project + `: $.sources.aws.secrets.API_KEY: must have the form 'id#field'`,
+		project + `: $.sources.aws.regoin: unknown field "regoin" (did you mean "region"?)`,
+		project + `: $.sources.vault: vault CLI not found: exec: "vault": executable file not found in $PATH`,
+	}
+	if got := strings.Split(strings.TrimSpace(out.String()), "\n"); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected output:\n%s", out.String())
+	}
+
+	out.Reset()
+	if problems := validateConfig(&out, []string{project}, "", true); problems != 4 || !strings.Contains(out.String(), "$.storage: is required") {
+		t.Errorf("expected storage to be required, got:\n%s", out.String())
+	}
+}
+
+func TestValidateTargetsChecksFilesOnTheirOwn(t *testing.T) {
+	global := filepath.Join(t.TempDir(), "global.json")
+	if err := os.WriteFile(global, []byte(`{"storage": {"type": "file"}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	withDefaultFile(t, global)
+	project := filepath.Join(t.TempDir(), projectConfigName)
+
+	if got := validateTargets(Args{}, []string{project}, false); !reflect.DeepEqual(got, [][]string{{project}}) {
+		t.Errorf("expected the file to be checked alone, got %v", got)
+	}
+	if got := validateTargets(Args{}, []string{project}, true); !reflect.DeepEqual(got, [][]string{{global, project}}) {
+		t.Errorf("expected --with-global to layer the file on the global config, got %v", got)
+	}
+	if got := validateTargets(Args{ConfigFile: "explicit.json"}, nil, false); !reflect.DeepEqual(got, [][]string{{"explicit.json"}}) {
+		t.Errorf("expected the selected config without arguments, got %v", got)
+	}
+}
+
+func TestValidateConfigAcceptsValidConfig(t *testing.T) {
+	withFakeCLI(t, "doppler", respond(""))
+
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	content := `
+sources:
+  doppler: {project: app, env: dev}
+storage: {type: file}
+profiles:
+  prod:
+    sources:
+      doppler: {env: prd}
+`
+	if err := os.WriteFile(configFile, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	if problems := validateConfig(&out, []string{configFile}, "prod", true); problems != 0 || out.String() != configFile+": valid\n" {
+		t.Errorf("expected a valid config, got %d problems:\n%s", problems, out.String())
+	}
+	out.Reset()
+	if problems := validateConfig(&out, []string{configFile}, "qa", true); problems != 1 {
+		t.Errorf("expected an unknown profile to be reported, got:\n%s", out.String())
+	}
+}
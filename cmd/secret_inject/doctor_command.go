@@ -0,0 +1,225 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/napisani/secret_inject/internal/config"
+	"github.com/napisani/secret_inject/internal/schema"
+	"github.com/napisani/secret_inject/internal/source"
+	"github.com/napisani/secret_inject/internal/storage"
+)
+
+const doctorUsage = "usage: secret_inject doctor [--config <file>] [--profile <name>]"
+
+// runDoctorCommand checks the config file, the storage and every configured
+// source, and prints a checklist with hints for whatever is broken. It
+// returns 1 if any check failed.
+func runDoctorCommand(argv []string) int {
+	var args Args
+	flags := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	flags.StringVar(&args.ConfigFile, "config", "", configFlagUsage)
+	flags.StringVar(&args.Profile, "profile", "", profileFlagUsage)
+	flags.BoolVar(&args.Debug, "debug", false, "Enable debug logging")
+	if err := flags.Parse(argv); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		fmt.Fprintln(stderr, "Error:", err)
+		return 1
+	}
+	if flags.NArg() != 0 {
+		fmt.Fprintln(stderr, "Error:", doctorUsage)
+		return 1
+	}
+
+	configureLogging(args)
+
+	if !writeChecklist(os.Stdout, runDoctor(args)) {
+		return 1
+	}
+	return 0
+}
+
+// runDoctor runs the checks in order. Checks that depend on an earlier one
+// are skipped when it fails, so each problem is reported once.
+func runDoctor(args Args) []source.Check {
+	files := configFiles(args)
+	var checks []source.Check
+	if _, err := allowedConfigFiles(args); errors.Is(err, errNotAllowed) {
+		return append(checks, source.Check{
+			Name:   "config allowed",
+			Result: source.CheckFail,
+			Detail: files[len(files)-1] + " has not been allowed with its current content",
+			Hint:   "review it and run 'secret_inject allow'",
+		})
+	}
+	for _, file := range files {
+		if _, err := os.Stat(file); errors.Is(err, fs.ErrNotExist) {
+			return append(checks, source.Check{
+				Name:   "config file",
+				Result: source.CheckFail,
+				Detail: file + " not found",
+				Hint:   "create one with 'secret_inject config init'",
+			})
+		}
+		checks = append(checks, checkFilePermissions("config permissions", file))
+	}
+
+	cfg, err := config.ReadLayered(files...)
+	if err != nil {
+		return append(checks, source.Check{
+			Name:   "config file",
+			Result: source.CheckFail,
+			Detail: err.Error(),
+			Hint:   "fix the syntax with 'secret_inject config edit'",
+		})
+	}
+	checks = append(checks, source.Check{Name: "config file", Result: source.CheckPass, Detail: strings.Join(files, " + ")})
+
+	if profile := selectedProfile(args); profile != "" {
+		if err := cfg.ApplyProfile(profile); err != nil {
+			return append(checks, source.Check{
+				Name:   "profile",
+				Result: source.CheckFail,
+				Detail: err.Error(),
+				Hint:   "pass an existing profile with --profile or " + profileEnvVar,
+			})
+		}
+		checks = append(checks, source.Check{Name: "profile", Result: source.CheckPass, Detail: profile})
+	}
+
+	if err := cfg.Validate(); err != nil {
+		// List every schema error on its own line of the checklist.
+		problems := []error{err}
+		var errs schema.Errors
+		if errors.As(err, &errs) {
+			problems = problems[:0]
+			for _, problem := range errs {
+				problems = append(problems, problem)
+			}
+		}
+		for _, problem := range problems {
+			checks = append(checks, source.Check{
+				Name:   "config valid",
+				Result: source.CheckFail,
+				Detail: problem.Error(),
+				Hint:   "fix the config with 'secret_inject config edit'",
+			})
+		}
+		return checks
+	}
+	checks = append(checks, source.Check{Name: "config valid", Result: source.CheckPass})
+
+	checks = append(checks, checkStorage(cfg)...)
+	return append(checks, source.Diagnose(buildFullConfig(cfg))...)
+}
+
+// checkFilePermissions reports whether path is only accessible by its owner.
+func checkFilePermissions(name, path string) source.Check {
+	check := source.Check{Name: name, Result: source.CheckPass}
+	info, err := os.Stat(path)
+	if err != nil {
+		check.Result = source.CheckFail
+		check.Detail = err.Error()
+		return check
+	}
+
+	perm := info.Mode().Perm()
+	check.Detail = fmt.Sprintf("%s is %04o", path, perm)
+	if perm&0o077 != 0 {
+		check.Result = source.CheckFail
+		check.Detail += " (expected 0600)"
+		check.Hint = "run: chmod 600 " + path
+	}
+	return check
+}
+
+// checkStorage opens the configured storage and, when there is a cache,
+// makes sure it can be read.
+func checkStorage(cfg *config.Config) []source.Check {
+	stor, err := storage.Get(cfg.Storage, cfg.Namespace())
+	if err != nil {
+		return []source.Check{{
+			Name:   "storage",
+			Result: source.CheckFail,
+			Detail: err.Error(),
+			Hint:   storageHint(cfg),
+		}}
+	}
+
+	storageCheck := source.Check{Name: "storage", Result: source.CheckPass}
+	var info storage.Info
+	if inspector, ok := stor.(storage.Inspector); ok {
+		info = inspector.Info()
+		storageCheck.Detail = info.Type
+		if info.Backend != "" {
+			storageCheck.Detail += " (" + info.Backend + ")"
+		}
+	}
+	checks := []source.Check{storageCheck}
+
+	if !stor.HasCachedSecrets() {
+		return append(checks, source.Check{Name: "cache", Result: source.CheckPass, Detail: "empty"})
+	}
+	if info.Type == "file" || info.Type == "encrypted-file" {
+		check := checkFilePermissions("cache permissions", info.Location)
+		if check.Result == source.CheckFail {
+			// Rewriting the cache is simpler than fixing the mode by hand.
+			check.Hint = "run: secret_inject --clean"
+		}
+		checks = append(checks, check)
+	}
+
+	cacheCheck := source.Check{Name: "cache", Result: source.CheckPass, Detail: "readable"}
+	if cached, err := stor.GetCachedSecrets(); err != nil {
+		cacheCheck.Result = source.CheckFail
+		cacheCheck.Detail = err.Error()
+		cacheCheck.Hint = storageHint(cfg) + ", or clear it with 'secret_inject --clean'"
+	} else if cached != nil {
+		registerValues(cached)
+	}
+	return append(checks, cacheCheck)
+}
+
+func storageHint(cfg *config.Config) string {
+	switch cfg.Storage["type"] {
+	case "keyring":
+		return "unlock the keyring, adjust 'allowed_backends', or use the 'encrypted-file' storage"
+	case "encrypted-file":
+		return "check the cache passphrase (storage 'password' or SECRET_INJECT_PASSPHRASE)"
+	default:
+		return "check the 'storage' block of the config file"
+	}
+}
+
+// writeChecklist prints one line per check with its hint below it and
+// reports whether none failed.
+func writeChecklist(w io.Writer, checks []source.Check) bool {
+	ok := true
+	for _, check := range checks {
+		fmt.Fprintf(w, "[%s] %s", check.Result, check.Name)
+		if check.Detail != "" {
+			fmt.Fprintf(w, ": %s", check.Detail)
+		}
+		fmt.Fprintln(w)
+		if check.Hint != "" && check.Result != source.CheckPass {
+			fmt.Fprintf(w, "       %s\n", check.Hint)
+		}
+		if check.Result == source.CheckFail {
+			ok = false
+		}
+	}
+
+	if ok {
+		fmt.Fprintln(w, "\nAll checks passed")
+	} else {
+		fmt.Fprintln(w, "\nSome checks failed")
+	}
+	return ok
+}
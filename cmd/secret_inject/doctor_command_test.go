@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/napisani/secret_inject/internal/source"
+)
+
+func writeDoctorConfig(t *testing.T, content string, perm os.FileMode) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), perm); err != nil {
+		t.Fatalf("writing config: %v", err)
+	}
+	return path
+}
+
+func checksByName(checks []source.Check) map[string]source.Check {
+	byName := make(map[string]source.Check, len(checks))
+	for _, check := range checks {
+		byName[check.Name] = check
+	}
+	return byName
+}
+
+func TestRunDoctorPasses(t *testing.T) {
+	withFakeCLI(t, "doppler", respond("v3.68.0\n"))
+	configFile := writeDoctorConfig(t, `{
+  "sources": {"doppler": {"project": "proj", "env": "dev"}},
+  "storage": {"type": "encrypted-file", "directory": "`+t.TempDir()+`", "password": "correct horse"}
+}`, 0o600)
+
+	checks := runDoctor(Args{ConfigFile: configFile})
+
+	var buf bytes.Buffer
+	if !writeChecklist(&buf, checks) {
+		t.Fatalf("expected every check to pass:\n%s", buf.String())
+	}
+	byName := checksByName(checks)
+	for _, name := range []string{"config permissions", "config file", "config valid", "storage", "cache", "doppler: doppler CLI", "doppler: version", "doppler: authenticated"} {
+		if _, ok := byName[name]; !ok {
+			t.Errorf("expected check %q in:\n%s", name, buf.String())
+		}
+	}
+	if !strings.Contains(buf.String(), "[ok] doppler: version: v3.68.0") {
+		t.Errorf("expected version in checklist:\n%s", buf.String())
+	}
+}
+
+func TestRunDoctorReportsProblems(t *testing.T) {
+	withFakeCLI(t, "doppler", func(args ...string) ([]byte, error) {
+		if args[0] == "me" {
+			return nil, errors.New("Unable to authenticate")
+		}
+		return []byte("v3.68.0\n"), nil
+	})
+	configFile := writeDoctorConfig(t, `{
+  "sources": {"doppler": {"project": "proj", "env": "dev"}},
+  "storage": {"type": "encrypted-file", "directory": "`+t.TempDir()+`", "password": "correct horse"}
+}`, 0o644)
+
+	checks := runDoctor(Args{ConfigFile: configFile})
+
+	var buf bytes.Buffer
+	if writeChecklist(&buf, checks) {
+		t.Fatalf("expected failures:\n%s", buf.String())
+	}
+	byName := checksByName(checks)
+	if check := byName["config permissions"]; check.Result != source.CheckFail || !strings.Contains(check.Hint, "chmod 600") {
+		t.Errorf("expected insecure config to fail, got %+v", check)
+	}
+	if check := byName["doppler: authenticated"]; check.Result != source.CheckFail || !strings.Contains(check.Detail, "Unable to authenticate") {
+		t.Errorf("expected auth failure, got %+v", check)
+	}
+	if !strings.Contains(buf.String(), "doppler login") {
+		t.Errorf("expected remediation hint in checklist:\n%s", buf.String())
+	}
+}
+
+func TestRunDoctorStopsAtInvalidConfig(t *testing.T) {
+	missing := runDoctor(Args{ConfigFile: filepath.Join(t.TempDir(), "missing.json")})
+	if len(missing) != 1 || missing[0].Result != source.CheckFail || !strings.Contains(missing[0].Hint, "config init") {
+		t.Errorf("expected a single missing config failure, got %+v", missing)
+	}
+
+	invalid := runDoctor(Args{ConfigFile: writeDoctorConfig(t, `{"sources": `, 0o600)})
+	if last := invalid[len(invalid)-1]; last.Name != "config file" || last.Result != source.CheckFail {
+		t.Errorf("expected invalid JSON to fail the config file check, got %+v", invalid)
+	}
+
+	typos := runDoctor(Args{ConfigFile: writeDoctorConfig(t, `{"sources": {"doppler": {"projct": "app"}}, "storage": {"type": "file"}}`, 0o600)})
+	var problems []string
+	for _, check := range typos {
+		if check.Name == "config valid" && check.Result == source.CheckFail {
+			problems = append(problems, check.Detail)
+		}
+	}
+	if len(problems) != 3 || typos[len(typos)-1].Name != "config valid" {
+		t.Errorf("expected every schema error as its own check, got %+v", typos)
+	}
+}
+
+func TestRunDoctorStopsAtConfigNotAllowed(t *testing.T) {
+	calls := 0
+	withFakeCLI(t, "doppler", func(...string) ([]byte, error) {
+		calls++
+		return []byte("v3.68.0\n"), nil
+	})
+	withDefaultFile(t, filepath.Join(t.TempDir(), "missing.json"))
+	root := t.TempDir()
+	content := `{"sources": {"doppler": {"project": "proj", "env": "dev"}}, "storage": {"type": "file"}}`
+	if err := os.WriteFile(filepath.Join(root, projectConfigName), []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	withWorkingDir(t, root)
+
+	checks := runDoctor(Args{})
+	if len(checks) != 1 || checks[0].Name != "config allowed" || checks[0].Result != source.CheckFail {
+		t.Fatalf("expected only the allow check to fail, got %+v", checks)
+	}
+	if calls != 0 {
+		t.Errorf("expected no CLI to run for a config that is not allowed, got %d calls", calls)
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/napisani/secret_inject/internal/source"
+)
+
+// writeExplainReport prints which source supplied each secret and which
+// sources' values it replaced. Secret values are never printed.
+func writeExplainReport(w io.Writer, report *source.Report) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tSOURCE\tOVERRODE")
+	for _, key := range report.Keys() {
+		overrode := "-"
+		if losers := report.Overrode(key); len(losers) > 0 {
+			overrode = strings.Join(losers, ", ")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", key, report.Origins[key], overrode)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/napisani/secret_inject/internal/source"
+)
+
+func TestWriteExplainReport(t *testing.T) {
+	report := &source.Report{
+		Origins: map[string]string{
+			"API_KEY": "onepassword",
+			"DB_URL":  "doppler",
+		},
+		Collisions: []source.Collision{
+			{Key: "API_KEY", Winner: "onepassword", Loser: "doppler"},
+		},
+	}
+
+	var buf bytes.Buffer
+	writeExplainReport(&buf, report)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected header and 2 rows, got %q", buf.String())
+	}
+	if fields := strings.Fields(lines[1]); len(fields) != 3 || fields[0] != "API_KEY" || fields[1] != "onepassword" || fields[2] != "doppler" {
+		t.Fatalf("unexpected API_KEY row %q", lines[1])
+	}
+	if fields := strings.Fields(lines[2]); len(fields) != 3 || fields[0] != "DB_URL" || fields[2] != "-" {
+		t.Fatalf("unexpected DB_URL row %q", lines[2])
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+
+	"github.com/napisani/secret_inject/internal/config"
+	"github.com/napisani/secret_inject/internal/storage"
+)
+
+const getUsage = "usage: secret_inject get [flags] KEY"
+
+// exitSecretNotFound is the exit status of get when the key does not exist
+// and no --default was given.
+const exitSecretNotFound = 2
+
+// runGetCommand prints the raw value of a single secret. It returns the exit
+// code for the process.
+func runGetCommand(argv []string) int {
+	var args Args
+	var defaultValue string
+	fs := flag.NewFlagSet("get", flag.ContinueOnError)
+	registerFetchFlags(fs, &args)
+	fs.StringVar(&defaultValue, "default", "", "Value to print when the secret does not exist")
+	if err := fs.Parse(argv); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		fmt.Fprintln(stderr, "Error:", err)
+		return 1
+	}
+
+	if fs.NArg() != 1 {
+		fmt.Fprintln(stderr, "Error:", getUsage)
+		return 1
+	}
+	key := fs.Arg(0)
+
+	hasDefault := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "default" {
+			hasDefault = true
+		}
+	})
+
+	configureLogging(args)
+
+	cfg, stor, err := setup(args)
+	if err != nil {
+		logSetupError(err)
+		return 1
+	}
+
+	value, found, stale, err := getSecret(cfg, stor, args, key)
+	if err != nil {
+		slog.Error("Error resolving secrets", "error", err)
+		return 1
+	}
+	if !found {
+		if !hasDefault {
+			fmt.Fprintf(stderr, "Error: secret %s not found\n", key)
+			return exitSecretNotFound
+		}
+		value = defaultValue
+	}
+
+	// Printed unquoted; command substitution strips the trailing newline.
+	fmt.Println(value)
+	if stale {
+		return exitStaleSecrets
+	}
+	return 0
+}
+
+// getSecret resolves a single secret, honouring the same filters as the
+// default export mode.
+func getSecret(cfg *config.Config, stor storage.Storage, args Args, key string) (value string, found bool, stale bool, err error) {
+	secrets, stale, err := loadSecrets(cfg, stor, args, key)
+	if err != nil {
+		return "", false, false, err
+	}
+
+	secrets, err = applyFilters(cfg, args, secrets)
+	if err != nil {
+		return "", false, false, err
+	}
+
+	value, found = secrets.Entries[key]
+	return value, found, stale, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/napisani/secret_inject/internal/filter"
+	"github.com/napisani/secret_inject/internal/secret"
+)
+
+func TestGetSecretFromCache(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.Filter = &filter.Config{Exclude: []string{"HIDDEN"}}
+	cached := secret.New()
+	cached.Entries["DB_URL"] = "postgres://user:pw@host/db"
+	cached.Entries["HIDDEN"] = "value"
+	cached.Fingerprint = cfg.SourcesFingerprint()
+	stor := &memoryStorage{cached: cached}
+
+	value, found, stale, err := getSecret(cfg, stor, Args{TTL: time.Hour}, "DB_URL")
+	if err != nil {
+		t.Fatalf("getSecret failed: %v", err)
+	}
+	if !found || stale || value != "postgres://user:pw@host/db" {
+		t.Fatalf("got value %q found %v stale %v", value, found, stale)
+	}
+
+	for _, key := range []string{"MISSING", "HIDDEN"} {
+		if _, found, _, err := getSecret(cfg, stor, Args{TTL: time.Hour}, key); err != nil || found {
+			t.Errorf("%s: expected not found, got found %v err %v", key, found, err)
+		}
+	}
+}
+
+func TestGetSecretRefreshesOnlyItsOrigin(t *testing.T) {
+	opCalled := false
+	withFakeCLI(t, "doppler", respond(`{"DB_URL":{"computed":"postgres://fresh"}}`))
+	withFakeCLI(t, "op", func(...string) ([]byte, error) {
+		opCalled = true
+		return []byte("fresh-key\n"), nil
+	})
+
+	cfg := newTestConfig()
+	cfg.Sources = map[string]interface{}{
+		"doppler":     map[string]interface{}{"project": "proj", "env": "dev"},
+		"onepassword": map[string]interface{}{"secrets": map[string]interface{}{"API_KEY": "op://vault/item/key"}},
+	}
+
+	// Both sources expired long ago.
+	fetchedAt := time.Now().Add(-2 * time.Hour)
+	cached := secret.New()
+	cached.Set("DB_URL", "postgres://cached", "proj/dev")
+	cached.Set("API_KEY", "cached-key", "op://vault/item/key")
+	cached.Stamp("doppler", fetchedAt)
+	cached.Metadata["API_KEY"] = secret.Metadata{Source: "onepassword", FetchedAt: fetchedAt}
+	cached.Fetches["doppler"] = secret.Fetch{FetchedAt: fetchedAt, Keys: []string{"DB_URL"}}
+	cached.Fetches["onepassword"] = secret.Fetch{FetchedAt: fetchedAt, Keys: []string{"API_KEY"}}
+	cached.Fingerprint = cfg.SourcesFingerprint()
+	stor := &memoryStorage{cached: cached}
+
+	value, found, _, err := getSecret(cfg, stor, Args{TTL: time.Hour}, "DB_URL")
+	if err != nil {
+		t.Fatalf("getSecret failed: %v", err)
+	}
+	if !found || value != "postgres://fresh" {
+		t.Fatalf("got value %q found %v", value, found)
+	}
+	if opCalled {
+		t.Fatal("expected the unrelated 1Password source not to be fetched")
+	}
+
+	// The unrelated source keeps its old fetch time, so it is still
+	// refreshed by the next full resolve.
+	if stor.cached.Entries["API_KEY"] != "cached-key" {
+		t.Fatalf("expected cached 1Password entry to be kept, got %v", stor.cached.Entries)
+	}
+	if !stor.cached.Fetches["onepassword"].FetchedAt.Equal(fetchedAt) {
+		t.Fatal("expected unrelated fetch time to be preserved")
+	}
+}
+
+func TestGetSecretServesStaleOriginOnError(t *testing.T) {
+	withFailingDoppler(t)
+	withFakeCLI(t, "op", fail("not signed in"))
+
+	cfg := newTestConfig()
+	cfg.Sources = map[string]interface{}{
+		"doppler":     map[string]interface{}{"project": "proj", "env": "dev"},
+		"onepassword": map[string]interface{}{"secrets": map[string]interface{}{"API_KEY": "op://vault/item/key"}},
+	}
+	cfg.StaleIfError = "24h"
+
+	// Only the unrelated 1Password source expired beyond the window.
+	cached := newDopplerCache(cfg, time.Now().Add(-2*time.Hour))
+	opFetchedAt := time.Now().Add(-48 * time.Hour)
+	cached.Set("API_KEY", "cached-key", "op://vault/item/key")
+	cached.Metadata["API_KEY"] = secret.Metadata{Source: "onepassword", FetchedAt: opFetchedAt}
+	cached.Fetches["onepassword"] = secret.Fetch{FetchedAt: opFetchedAt, Keys: []string{"API_KEY"}}
+	stor := &memoryStorage{cached: cached}
+
+	value, found, stale, err := getSecret(cfg, stor, Args{TTL: time.Hour}, "DB_URL")
+	if err != nil {
+		t.Fatalf("expected the key's own expired source to be served, got %v", err)
+	}
+	if !found || !stale || value != "postgres://cached" {
+		t.Fatalf("got value %q found %v stale %v", value, found, stale)
+	}
+
+	if _, _, _, err := getSecret(cfg, stor, Args{TTL: time.Hour}, "API_KEY"); err == nil {
+		t.Fatal("expected a key whose source expired beyond the window to fail")
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/napisani/secret_inject/internal/output"
+	"github.com/napisani/secret_inject/internal/secret"
+)
+
+const hookUsage = "usage: secret_inject hook [--apply] <bash|zsh|fish>"
+
+// The hook keeps its state in the shell's environment: the config and
+// profile it loaded and the keys it exported from them, separated by colons.
+// After loading failed, or while the config is not allowed, the retry
+// variable holds the Unix time from which the hook tries again.
+const (
+	hookConfigVar  = "SECRET_INJECT_HOOK_CONFIG"
+	hookProfileVar = "SECRET_INJECT_HOOK_PROFILE"
+	hookKeysVar    = "SECRET_INJECT_HOOK_KEYS"
+	hookRetryVar   = "SECRET_INJECT_HOOK_RETRY"
+)
+
+// hookRetryDelay is how long the hook waits before it loads a project's
+// secrets again after loading them failed.
+const hookRetryDelay = time.Minute
+
+const bashHook = `_secret_inject_hook() {
+  local previous_exit_status=$?
+  eval "$(%[1]s hook --apply bash)"
+  return $previous_exit_status
+}
+if [[ ";${PROMPT_COMMAND[*]:-};" != *";_secret_inject_hook;"* ]]; then
+  PROMPT_COMMAND="_secret_inject_hook${PROMPT_COMMAND:+;$PROMPT_COMMAND}"
+fi
+`
+
+const zshHook = `_secret_inject_hook() {
+  eval "$(%[1]s hook --apply zsh)"
+}
+typeset -ag precmd_functions chpwd_functions
+if (( ! ${precmd_functions[(I)_secret_inject_hook]} )); then
+  precmd_functions=(_secret_inject_hook $precmd_functions)
+fi
+if (( ! ${chpwd_functions[(I)_secret_inject_hook]} )); then
+  chpwd_functions=(_secret_inject_hook $chpwd_functions)
+fi
+`
+
+const fishHook = `function __secret_inject_hook --on-variable PWD --on-event fish_prompt
+    %[1]s hook --apply fish | source
+end
+`
+
+// hookShells maps each supported shell to its hook script and the output
+// format its export commands use.
+var hookShells = map[string]struct {
+	script string
+	format string
+}{
+	"bash": {bashHook, "shell"},
+	"zsh":  {zshHook, "shell"},
+	"fish": {fishHook, "fish"},
+}
+
+// runHookCommand prints the prompt hook for a shell or, with --apply, the
+// commands the hook evaluates for the current directory. It returns the
+// exit code for the process.
+func runHookCommand(argv []string) int {
+	var args Args
+	var apply bool
+	fs := flag.NewFlagSet("hook", flag.ContinueOnError)
+	fs.BoolVar(&apply, "apply", false, "Print the commands that load or unload secrets for the current directory")
+	fs.BoolVar(&args.Debug, "debug", false, "Enable debug logging")
+	fs.DurationVar(&args.TTL, "ttl", 1*time.Hour, "Cache TTL duration (e.g., '1h', '30m')")
+	if err := fs.Parse(argv); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		fmt.Fprintln(stderr, "Error:", err)
+		return 1
+	}
+
+	shell, ok := hookShells[fs.Arg(0)]
+	if fs.NArg() != 1 || !ok {
+		fmt.Fprintln(stderr, "Error:", hookUsage)
+		return 1
+	}
+
+	configureLogging(args)
+
+	if !apply {
+		fmt.Printf(shell.script, hookExecutable())
+		return 0
+	}
+
+	dir, err := os.Getwd()
+	if err != nil {
+		fmt.Fprintln(stderr, "Error:", err)
+		return 1
+	}
+	state := hookState{
+		config:  os.Getenv(hookConfigVar),
+		profile: os.Getenv(hookProfileVar),
+		keys:    os.Getenv(hookKeysVar),
+		retry:   os.Getenv(hookRetryVar),
+	}
+	if err := applyHook(os.Stdout, shell.format, dir, state, time.Now(), args); err != nil {
+		fmt.Fprintln(stderr, "secret_inject:", err)
+		return 1
+	}
+	return 0
+}
+
+// hookExecutable returns this binary's path quoted for the hook scripts, so
+// the hook keeps working when secret_inject is not on PATH.
+func hookExecutable() string {
+	executable, err := os.Executable()
+	if err != nil {
+		return "secret_inject"
+	}
+	return "'" + strings.ReplaceAll(executable, "'", `'\''`) + "'"
+}
+
+// hookState is what the hook loaded into the shell on a previous prompt.
+type hookState struct {
+	config  string
+	profile string
+	keys    string
+	retry   string
+}
+
+// due reports whether the hook should try to load the state's config again.
+// It never has to once the config's secrets were loaded.
+func (s hookState) due(now time.Time) bool {
+	if s.retry == "" {
+		return false
+	}
+	retryAt, err := strconv.ParseInt(s.retry, 10, 64)
+	return err != nil || now.Unix() >= retryAt
+}
+
+// applyHook writes the commands that bring the shell in line with the
+// project config for dir and the selected profile: nothing while the same
+// config and profile stay in effect, otherwise unsetting the keys exported
+// for the previous ones and then exporting the secrets of the new one along
+// with the hook state.
+//
+// Only configs allowed with "secret_inject allow" are loaded (see
+// checkAllowed). An unknown or edited config is reported once and checked
+// again at every prompt. If the secrets cannot be resolved, the previous keys are still
+// unset and the error is returned; loading is retried after hookRetryDelay.
+func applyHook(w io.Writer, format, dir string, state hookState, now time.Time, args Args) error {
+	configFile := findProjectConfig(dir)
+	profile := ""
+	if configFile != "" {
+		profile = selectedProfile(args)
+	}
+	retrying := false
+	if configFile == state.config && profile == state.profile {
+		if !state.due(now) {
+			return nil
+		}
+		retrying = true
+	}
+
+	if state.config != "" {
+		slog.Debug("Unloading secrets", "config", state.config, "profile", state.profile)
+		keys := append(splitHookKeys(state.keys), hookConfigVar, hookProfileVar, hookKeysVar)
+		if state.retry != "" {
+			keys = append(keys, hookRetryVar)
+		}
+		if err := output.Unset(w, keys, format); err != nil {
+			return err
+		}
+	}
+	if configFile == "" {
+		return nil
+	}
+
+	// pending records the config without any secrets, so the hook waits
+	// until retryAt before it tries again.
+	pending := func(retryAt time.Time) error {
+		exported := secret.New()
+		exported.Entries[hookConfigVar] = configFile
+		exported.Entries[hookProfileVar] = profile
+		exported.Entries[hookKeysVar] = ""
+		exported.Entries[hookRetryVar] = strconv.FormatInt(retryAt.Unix(), 10)
+		return output.Write(w, exported, format)
+	}
+
+	if err := checkAllowed(configFile); err != nil {
+		if !errors.Is(err, errNotAllowed) {
+			return err
+		}
+		if err := pending(now); err != nil {
+			return err
+		}
+		if retrying {
+			return nil
+		}
+		return err
+	}
+
+	slog.Debug("Loading secrets", "config", configFile, "profile", profile)
+	secrets, err := loadHookSecrets(configFile, profile, args)
+	if err != nil {
+		if err := pending(now.Add(hookRetryDelay)); err != nil {
+			return err
+		}
+		return fmt.Errorf("%w (retrying in %s)", err, hookRetryDelay)
+	}
+
+	keys := make([]string, 0, len(secrets.Entries))
+	exported := secret.New()
+	for key, value := range secrets.Entries {
+		keys = append(keys, key)
+		exported.Entries[key] = value
+	}
+	sort.Strings(keys)
+	exported.Entries[hookConfigVar] = configFile
+	exported.Entries[hookProfileVar] = profile
+	exported.Entries[hookKeysVar] = strings.Join(keys, ":")
+	return output.Write(w, exported, format)
+}
+
+func loadHookSecrets(configFile, profile string, args Args) (*secret.Secrets, error) {
+	cfg, stor, err := setupFiles(projectConfigFiles(configFile), profile)
+	if err != nil {
+		return nil, err
+	}
+	secrets, _, err := resolveSecrets(cfg, stor, args)
+	return secrets, err
+}
+
+func splitHookKeys(keys string) []string {
+	if keys == "" {
+		return nil
+	}
+	return strings.Split(keys, ":")
+}
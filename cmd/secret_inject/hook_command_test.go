@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newHookProject writes a project config for a doppler source into a new
+// directory, allows it and returns the directory and the
+// config path.
+func newHookProject(t *testing.T) (string, string) {
+	t.Helper()
+	withDefaultFile(t, filepath.Join(t.TempDir(), "missing.json"))
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, projectConfigName)
+	content := `{
+  "sources": {"doppler": {"project": "proj", "env": "dev"}},
+  "storage": {"type": "encrypted-file", "directory": "` + t.TempDir() + `", "password": "correct horse"}
+}`
+	if err := os.WriteFile(configFile, []byte(content), 0o600); err != nil {
+		t.Fatalf("writing config: %v", err)
+	}
+	if _, err := allowConfig(configFile); err != nil {
+		t.Fatalf("allowing config: %v", err)
+	}
+	return dir, configFile
+}
+
+func TestApplyHookLoadsProjectSecrets(t *testing.T) {
+	withFakeCLI(t, "doppler", respond(`{"DB_URL":{"computed":"postgres://it's"},"API_KEY":{"computed":"key"}}`))
+	dir, configFile := newHookProject(t)
+	sub := filepath.Join(dir, "src", "app")
+	if err := os.MkdirAll(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := applyHook(&buf, "shell", sub, hookState{}, time.Now(), Args{TTL: time.Hour}); err != nil {
+		t.Fatalf("applyHook failed: %v", err)
+	}
+
+	want := "export API_KEY='key'\n" +
+		"export DB_URL='postgres://it'\\''s'\n" +
+		"export SECRET_INJECT_HOOK_CONFIG='" + configFile + "'\n" +
+		"export SECRET_INJECT_HOOK_KEYS='API_KEY:DB_URL'\n" +
+		"export SECRET_INJECT_HOOK_PROFILE=''\n"
+	if buf.String() != want {
+		t.Fatalf("unexpected hook output:\n%s\nwant:\n%s", buf.String(), want)
+	}
+
+	buf.Reset()
+	state := hookState{config: configFile, keys: "API_KEY:DB_URL"}
+	if err := applyHook(&buf, "shell", dir, state, time.Now(), Args{TTL: time.Hour}); err != nil {
+		t.Fatalf("applyHook failed: %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("expected no output while the config stays in effect, got:\n%s", buf.String())
+	}
+}
+
+func TestApplyHookUnloadsOnLeave(t *testing.T) {
+	withDefaultFile(t, filepath.Join(t.TempDir(), "missing.json"))
+	state := hookState{config: "/elsewhere/" + projectConfigName, keys: "API_KEY:DB_URL"}
+
+	var buf bytes.Buffer
+	if err := applyHook(&buf, "fish", t.TempDir(), state, time.Now(), Args{TTL: time.Hour}); err != nil {
+		t.Fatalf("applyHook failed: %v", err)
+	}
+
+	want := "set -e API_KEY\nset -e DB_URL\nset -e SECRET_INJECT_HOOK_CONFIG\nset -e SECRET_INJECT_HOOK_PROFILE\nset -e SECRET_INJECT_HOOK_KEYS\n"
+	if buf.String() != want {
+		t.Fatalf("unexpected hook output:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestApplyHookUnsetsPreviousKeysWhenLoadFails(t *testing.T) {
+	withFailingDoppler(t)
+	dir, _ := newHookProject(t)
+	state := hookState{config: "/elsewhere/" + projectConfigName, keys: "OLD_KEY"}
+
+	var buf bytes.Buffer
+	err := applyHook(&buf, "shell", dir, state, time.Now(), Args{TTL: time.Hour})
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if !strings.HasPrefix(buf.String(), "unset OLD_KEY\n") || strings.Contains(buf.String(), "export DB_URL") {
+		t.Fatalf("expected the previous keys to be unset and nothing loaded, got:\n%s", buf.String())
+	}
+}
+
+func TestApplyHookRequiresAllowedConfig(t *testing.T) {
+	calls := 0
+	withFakeCLI(t, "doppler", func(...string) ([]byte, error) {
+		calls++
+		return []byte(`{"API_KEY":{"computed":"key"}}`), nil
+	})
+	dir, configFile := newHookProject(t)
+	content, err := os.ReadFile(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(configFile, append(content, '\n'), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+
+	var buf bytes.Buffer
+	err = applyHook(&buf, "shell", dir, hookState{}, now, Args{TTL: time.Hour})
+	if !errors.Is(err, errNotAllowed) {
+		t.Fatalf("expected an edited config to need allowing again, got %v", err)
+	}
+	if calls != 0 || strings.Contains(buf.String(), "API_KEY=") {
+		t.Fatalf("expected nothing to be loaded, got %d fetches and:\n%s", calls, buf.String())
+	}
+
+	// The next prompt checks again without repeating the error.
+	state := hookState{config: configFile, retry: strconv.FormatInt(now.Unix(), 10)}
+	buf.Reset()
+	if err := applyHook(&buf, "shell", dir, state, now, Args{TTL: time.Hour}); err != nil {
+		t.Fatalf("expected the error to be reported once, got %v", err)
+	}
+
+	if _, err := allowConfig(configFile); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := applyHook(&buf, "shell", dir, state, now, Args{TTL: time.Hour}); err != nil {
+		t.Fatalf("applyHook failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "unset "+hookRetryVar) || !strings.Contains(buf.String(), "export API_KEY='key'") {
+		t.Fatalf("expected the allowed config to be loaded, got:\n%s", buf.String())
+	}
+}
+
+func TestApplyHookThrottlesRetries(t *testing.T) {
+	calls := 0
+	withFakeCLI(t, "doppler", func(...string) ([]byte, error) {
+		calls++
+		return nil, errors.New("Unable to reach Doppler")
+	})
+	dir, configFile := newHookProject(t)
+	now := time.Now()
+
+	var buf bytes.Buffer
+	if err := applyHook(&buf, "shell", dir, hookState{}, now, Args{TTL: time.Hour}); err == nil {
+		t.Fatal("expected an error")
+	}
+	retryAt := strconv.FormatInt(now.Add(hookRetryDelay).Unix(), 10)
+	if !strings.Contains(buf.String(), "export "+hookRetryVar+"='"+retryAt+"'") {
+		t.Fatalf("expected the retry time to be recorded, got:\n%s", buf.String())
+	}
+	fetches := calls
+
+	state := hookState{config: configFile, retry: retryAt}
+	buf.Reset()
+	if err := applyHook(&buf, "shell", dir, state, now.Add(time.Second), Args{TTL: time.Hour}); err != nil || buf.Len() != 0 || calls != fetches {
+		t.Fatalf("expected no retry before %s, got error %v, %d fetches and:\n%s", retryAt, err, calls-fetches, buf.String())
+	}
+
+	if err := applyHook(&buf, "shell", dir, state, now.Add(hookRetryDelay), Args{TTL: time.Hour}); err == nil || calls == fetches {
+		t.Fatalf("expected a retry once the delay passed, got error %v", err)
+	}
+}
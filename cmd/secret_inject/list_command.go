@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/napisani/secret_inject/internal/secret"
+)
+
+const listUsage = "usage: secret_inject list [flags]"
+
+// previewMinLength is the shortest value whose first and last characters
+// are shown with --preview; shorter values would be given away.
+const previewMinLength = 12
+
+// listEntry describes one secret without its value.
+type listEntry struct {
+	Key       string     `json:"key"`
+	Source    string     `json:"source,omitempty"`
+	Reference string     `json:"reference,omitempty"`
+	FetchedAt *time.Time `json:"fetched_at,omitempty"`
+	Age       string     `json:"age,omitempty"`
+	Length    int        `json:"length"`
+	Preview   string     `json:"preview"`
+}
+
+// runListCommand prints the resolved secret names with their provenance and
+// a masked preview of each value. It returns the exit code for the process.
+func runListCommand(argv []string) int {
+	var args Args
+	var preview bool
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	registerFetchFlags(fs, &args)
+	fs.StringVar(&args.Output, "output", "table", "Output format: table, json")
+	fs.BoolVar(&preview, "preview", false, "Show the first and last character of long values")
+	if err := fs.Parse(argv); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		fmt.Fprintln(stderr, "Error:", err)
+		return 1
+	}
+
+	if fs.NArg() != 0 || (args.Output != "table" && args.Output != "json") {
+		fmt.Fprintln(stderr, "Error:", listUsage, "(--output must be table or json)")
+		return 1
+	}
+
+	configureLogging(args)
+
+	cfg, stor, err := setup(args)
+	if err != nil {
+		logSetupError(err)
+		return 1
+	}
+
+	secrets, stale, err := resolveSecrets(cfg, stor, args)
+	if err != nil {
+		slog.Error("Error resolving secrets", "error", err)
+		return 1
+	}
+
+	entries := listEntries(secrets, time.Now(), preview)
+	if args.Output == "json" {
+		err = writeListJSON(os.Stdout, entries)
+	} else {
+		writeListTable(os.Stdout, entries)
+	}
+	if err != nil {
+		slog.Error("Error writing list", "error", err)
+		return 1
+	}
+
+	if stale {
+		return exitStaleSecrets
+	}
+	return 0
+}
+
+// listEntries describes every secret in key order. Values are reduced to
+// their length and, with preview, their first and last characters.
+func listEntries(secrets *secret.Secrets, now time.Time, preview bool) []listEntry {
+	keys := make([]string, 0, len(secrets.Entries))
+	for key := range secrets.Entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	entries := make([]listEntry, 0, len(keys))
+	for _, key := range keys {
+		value := []rune(secrets.Entries[key])
+		entry := listEntry{
+			Key:     key,
+			Length:  len(value),
+			Preview: maskValue(value, preview),
+		}
+		if meta, ok := secrets.Meta(key); ok {
+			entry.Source = meta.Source
+			entry.Reference = meta.Reference
+			if !meta.FetchedAt.IsZero() {
+				fetchedAt := meta.FetchedAt
+				entry.FetchedAt = &fetchedAt
+				entry.Age = now.Sub(fetchedAt).Round(time.Second).String()
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func maskValue(value []rune, preview bool) string {
+	if preview && len(value) >= previewMinLength {
+		return fmt.Sprintf("%c******%c", value[0], value[len(value)-1])
+	}
+	return "********"
+}
+
+func writeListTable(w io.Writer, entries []listEntry) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tSOURCE\tAGE\tLENGTH\tVALUE")
+	for _, entry := range entries {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\n",
+			entry.Key, orDash(entry.Source), orDash(entry.Age), entry.Length, entry.Preview)
+	}
+	tw.Flush()
+}
+
+func writeListJSON(w io.Writer, entries []listEntry) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(entries)
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/napisani/secret_inject/internal/secret"
+)
+
+func newListSecrets(fetchedAt time.Time) *secret.Secrets {
+	secrets := secret.New()
+	secrets.Set("DB_URL", "postgres://user:pw@host/db", "proj/dev")
+	secrets.Stamp("doppler", fetchedAt)
+	secrets.Entries["PIN"] = "1234"
+	return secrets
+}
+
+func TestListEntriesMaskValues(t *testing.T) {
+	now := time.Now()
+	entries := listEntries(newListSecrets(now.Add(-90*time.Second)), now, false)
+
+	if len(entries) != 2 || entries[0].Key != "DB_URL" || entries[1].Key != "PIN" {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+	db := entries[0]
+	if db.Source != "doppler" || db.Reference != "proj/dev" || db.Age != "1m30s" || db.Length != 26 {
+		t.Errorf("unexpected DB_URL entry: %+v", db)
+	}
+	if db.Preview != "********" {
+		t.Errorf("expected value to be fully masked, got %q", db.Preview)
+	}
+	if pin := entries[1]; pin.Source != "" || pin.FetchedAt != nil || pin.Length != 4 {
+		t.Errorf("expected PIN without provenance, got %+v", pin)
+	}
+}
+
+func TestListEntriesPreview(t *testing.T) {
+	now := time.Now()
+	entries := listEntries(newListSecrets(now), now, true)
+
+	if got := entries[0].Preview; got != "p******b" {
+		t.Errorf("DB_URL preview: got %q", got)
+	}
+	if got := entries[1].Preview; got != "********" {
+		t.Errorf("short values must stay masked, got %q", got)
+	}
+}
+
+func TestWriteListNeverPrintsValues(t *testing.T) {
+	now := time.Now()
+	entries := listEntries(newListSecrets(now.Add(-time.Minute)), now, true)
+
+	var table bytes.Buffer
+	writeListTable(&table, entries)
+	lines := strings.Split(strings.TrimSpace(table.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected header and 2 rows, got %q", table.String())
+	}
+	if fields := strings.Fields(lines[2]); len(fields) != 5 || fields[0] != "PIN" || fields[1] != "-" || fields[3] != "4" {
+		t.Fatalf("unexpected PIN row %q", lines[2])
+	}
+
+	var encoded bytes.Buffer
+	if err := writeListJSON(&encoded, entries); err != nil {
+		t.Fatalf("writeListJSON failed: %v", err)
+	}
+	var decoded []listEntry
+	if err := json.Unmarshal(encoded.Bytes(), &decoded); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if len(decoded) != 2 || decoded[0].Source != "doppler" {
+		t.Fatalf("unexpected JSON: %s", encoded.String())
+	}
+
+	for _, output := range []string{table.String(), encoded.String()} {
+		if strings.Contains(output, "user:pw") || strings.Contains(output, "1234") {
+			t.Fatalf("secret value leaked: %s", output)
+		}
+	}
+}
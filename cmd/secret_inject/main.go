@@ -3,30 +3,60 @@ package main
 import (
 	"flag"
 	"fmt"
+	"log"
 	"log/slog"
 	"os"
 	"path"
+	"strings"
 	"time"
 
-	"github.com/napisani/secret_inject/internal/config"
 	"github.com/napisani/secret_inject/internal/output"
-	"github.com/napisani/secret_inject/internal/secret"
-	"github.com/napisani/secret_inject/internal/source"
-	"github.com/napisani/secret_inject/internal/storage"
+	"github.com/napisani/secret_inject/internal/redact"
 )
 
 type Args struct {
 	ConfigFile string
+	Profile    string
 	Clean      bool
+	CleanAll   bool
 	Debug      bool
 	Force      bool
+	Explain    bool
 	TTL        time.Duration
 	Output     string
 	Version    bool
+	Only       stringList
+	Exclude    stringList
 }
 
+// stringList is a repeatable flag whose values may also be comma-separated.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			*l = append(*l, part)
+		}
+	}
+	return nil
+}
+
+// stderr masks fetched secret values in everything the tool itself reports.
+var stderr = redact.Writer(os.Stderr)
+
 var defaultFile = path.Join(os.Getenv("HOME"), ".config", ".secret_inject.json")
 
+// configFlagUsage describes --config for the commands that discover the
+// config when it is not given.
+var configFlagUsage = "Config file path (default: the nearest " + projectConfigName +
+	" (or .yaml, .yml, .toml) layered on " + defaultFile + ")"
+
+var profileFlagUsage = "Config profile to use (default: $" + profileEnvVar + ")"
+
 // Version information (set via ldflags)
 var (
 	Version   = "dev"
@@ -34,26 +64,64 @@ var (
 	BuildDate = "unknown"
 )
 
+// registerFetchFlags registers the flags shared by every command that
+// resolves secrets (the default export mode and subcommands such as run).
+func registerFetchFlags(fs *flag.FlagSet, args *Args) {
+	fs.StringVar(&args.ConfigFile, "config", "", configFlagUsage)
+	fs.StringVar(&args.Profile, "profile", "", profileFlagUsage)
+	fs.BoolVar(&args.Debug, "debug", false, "Enable debug logging")
+	fs.BoolVar(&args.Force, "force", false, "Force refresh, ignore cache")
+	fs.DurationVar(&args.TTL, "ttl", 1*time.Hour, "Cache TTL duration (e.g., '1h', '30m')")
+	fs.Var(&args.Only, "only", "Only include secrets matching these patterns (repeatable, comma-separated)")
+	fs.BoolVar(&args.Explain, "explain", false, "Print which source supplied each secret to stderr (implies --force)")
+	fs.Var(&args.Exclude, "exclude", "Exclude secrets matching these patterns (repeatable, comma-separated)")
+}
+
 func parseArgs() Args {
 	var args Args
-	flag.StringVar(&args.ConfigFile, "config", defaultFile, "Config file path")
-	flag.BoolVar(&args.Clean, "clean", false, "Clean cached secrets")
-	flag.BoolVar(&args.Debug, "debug", false, "Enable debug logging")
-	flag.BoolVar(&args.Force, "force", false, "Force refresh, ignore cache")
-	flag.DurationVar(&args.TTL, "ttl", 1*time.Hour, "Cache TTL duration (e.g., '1h', '30m')")
-	flag.StringVar(&args.Output, "output", "shell", "Output format: shell, json, env")
+	registerFetchFlags(flag.CommandLine, &args)
+	flag.BoolVar(&args.Clean, "clean", false, "Clean cached secrets for this config")
+	flag.BoolVar(&args.CleanAll, "all", false, "With --clean, clean cached secrets for every config")
+	flag.StringVar(&args.Output, "output", "shell", "Output format: shell, fish, json, env")
 	flag.BoolVar(&args.Version, "version", false, "Print version information")
 	flag.Parse()
 	return args
 }
 
+func configureLogging(args Args) {
+	if args.Debug {
+		slog.SetLogLoggerLevel(slog.LevelDebug)
+	}
+}
+
 func main() {
-	if len(os.Args) > 1 && os.Args[1] == "config" {
-		if err := runConfigCommand(os.Args[2:]); err != nil {
-			fmt.Fprintln(os.Stderr, "Error:", err)
-			os.Exit(1)
+	// slog's default handler writes through the log package, so this masks
+	// secret values in every log record as well.
+	log.SetOutput(stderr)
+
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "config":
+			if err := runConfigCommand(os.Args[2:]); err != nil {
+				fmt.Fprintln(stderr, "Error:", err)
+				os.Exit(1)
+			}
+			return
+		case "run":
+			os.Exit(runRunCommand(os.Args[2:]))
+		case "get":
+			os.Exit(runGetCommand(os.Args[2:]))
+		case "list":
+			os.Exit(runListCommand(os.Args[2:]))
+		case "status":
+			os.Exit(runStatusCommand(os.Args[2:]))
+		case "doctor":
+			os.Exit(runDoctorCommand(os.Args[2:]))
+		case "hook":
+			os.Exit(runHookCommand(os.Args[2:]))
+		case "allow":
+			os.Exit(runAllowCommand(os.Args[2:]))
 		}
-		return
 	}
 
 	args := parseArgs()
@@ -64,34 +132,24 @@ func main() {
 		return
 	}
 
-	// Configure logging
-	if args.Debug {
-		slog.SetLogLoggerLevel(slog.LevelDebug)
-	}
+	configureLogging(args)
 
-	// Read and validate config
-	cfg, err := config.ReadConfig(args.ConfigFile)
+	cfg, stor, err := setup(args)
 	if err != nil {
-		slog.Error("Error reading config file", "file", args.ConfigFile, "error", err)
-		os.Exit(1)
-	}
-
-	if err := cfg.Validate(); err != nil {
-		slog.Error("Invalid config", "error", err)
-		os.Exit(1)
-	}
-
-	// Initialize storage
-	stor, err := storage.Get(cfg.Storage)
-	if err != nil {
-		slog.Error("Error getting storage", "error", err)
+		logSetupError(err)
 		os.Exit(1)
 	}
 
 	// Handle clean command
 	if args.Clean {
-		slog.Debug("Cleaning cached secrets")
-		err := stor.CleanCachedSecrets()
+		var err error
+		if args.CleanAll {
+			slog.Debug("Cleaning cached secrets for all configs")
+			err = stor.CleanAllCachedSecrets()
+		} else {
+			slog.Debug("Cleaning cached secrets", "namespace", cfg.Namespace())
+			err = stor.CleanCachedSecrets()
+		}
 		if err != nil {
 			slog.Error("Error cleaning cached secrets", "error", err)
 			os.Exit(1)
@@ -100,70 +158,15 @@ func main() {
 		return
 	}
 
-	// Initialize sources
-	fullConfig := make(map[string]interface{})
-	fullConfig["sources"] = cfg.Sources
-	fullConfig["storage"] = cfg.Storage
-	if len(cfg.SourceSequence) > 0 {
-		fullConfig["source_sequence"] = cfg.SourceSequence
-	}
-
-	sources, err := source.LoadAll(fullConfig)
+	secrets, stale, err := resolveSecrets(cfg, stor, args)
 	if err != nil {
-		slog.Error("Error loading sources", "error", err)
+		slog.Error("Error resolving secrets", "error", err)
 		os.Exit(1)
 	}
 
-	if len(sources) == 0 {
-		slog.Debug("No sources enabled, continuing with empty secrets")
-	}
-
-	secrets := secret.New()
-
-	// Check if we should use cached secrets
-	useCached := stor.HasCachedSecrets() && !args.Force
-	if useCached {
-		slog.Debug("Found cached secrets")
-		secrets, err = stor.GetCachedSecrets()
-		if err != nil {
-			slog.Error("Error getting cached secrets", "error", err)
-			os.Exit(1)
-		}
-
-		// Check if cache is expired
-		if secrets.IsExpired(args.TTL) {
-			slog.Debug("Cached secrets expired", "age", time.Since(secrets.Timestamp))
-			useCached = false
-		}
-	}
-
-	// Fetch from sources if not using cache
-	if !useCached {
-		slog.Debug("Fetching secrets from sources")
-
-		for _, src := range sources {
-			// Check if source is enabled
-			if !src.IsEnabled() {
-				slog.Debug("Source disabled, skipping")
-				continue
-			}
-
-			moreSecrets, err := src.GetAllSecrets(secrets)
-			if err != nil {
-				slog.Error("Error getting secrets", "error", err)
-				os.Exit(1)
-			}
-			secrets = secrets.Append(moreSecrets)
-		}
-
-		// Cache the secrets
-		err = stor.CacheSecrets(secrets)
-		if err != nil {
-			slog.Error("Error caching secrets", "error", err)
-			os.Exit(1)
-		}
-	}
-
 	// Export secrets
 	output.Export(secrets, args.Output)
+	if stale {
+		os.Exit(exitStaleSecrets)
+	}
 }
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/napisani/secret_inject/internal/config"
+	"github.com/napisani/secret_inject/internal/filter"
+	"github.com/napisani/secret_inject/internal/redact"
+	"github.com/napisani/secret_inject/internal/schema"
+	"github.com/napisani/secret_inject/internal/secret"
+	"github.com/napisani/secret_inject/internal/source"
+	"github.com/napisani/secret_inject/internal/storage"
+)
+
+// exitStaleSecrets is the exit status when expired cached secrets were
+// served because fetching fresh ones failed (see stale_if_error).
+const exitStaleSecrets = 3
+
+// projectConfigName is the config file a project keeps at its root. The
+// same name with a YAML or TOML extension works as well.
+const projectConfigName = ".secret_inject.json"
+
+// configExtensions are tried in order wherever a config is looked up by
+// name.
+var configExtensions = []string{".json", ".yaml", ".yml", ".toml"}
+
+// profileEnvVar selects the config profile when --profile is not given.
+const profileEnvVar = "SECRET_INJECT_PROFILE"
+
+// setup reads and validates the config and opens the configured storage.
+func setup(args Args) (*config.Config, storage.Storage, error) {
+	files, err := allowedConfigFiles(args)
+	if err != nil {
+		return nil, nil, err
+	}
+	return setupFiles(files, selectedProfile(args))
+}
+
+// setupFiles works like setup for a config layered from files (see
+// config.ReadLayered) with the named profile applied.
+func setupFiles(files []string, profile string) (*config.Config, storage.Storage, error) {
+	cfg, err := config.ReadLayered(files...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading config file %s: %w", strings.Join(files, ", "), err)
+	}
+
+	if err := cfg.ApplyProfile(profile); err != nil {
+		return nil, nil, err
+	}
+	if profile != "" {
+		slog.Debug("Using config profile", "profile", profile)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	slog.Debug("Using cache namespace", "namespace", cfg.Namespace())
+	stor, err := storage.Get(cfg.Storage, cfg.Namespace())
+	if err != nil {
+		return nil, nil, fmt.Errorf("getting storage: %w", err)
+	}
+
+	return cfg, stor, nil
+}
+
+// logSetupError logs an error returned by setup, with every problem of an
+// invalid config on a line of its own.
+func logSetupError(err error) {
+	var errs schema.Errors
+	if errors.As(err, &errs) {
+		for _, problem := range errs {
+			slog.Error("Invalid config", "path", problem.Path, "problem", problem.Message)
+		}
+		return
+	}
+	slog.Error("Error initializing", "error", err)
+}
+
+// selectedProfile returns the profile named by --profile or, without it,
+// by SECRET_INJECT_PROFILE.
+func selectedProfile(args Args) string {
+	if args.Profile != "" {
+		return args.Profile
+	}
+	return os.Getenv(profileEnvVar)
+}
+
+// configFiles returns the files that make up the config: the --config file
+// if one was given, and otherwise the config of the project containing the
+// working directory.
+func configFiles(args Args) []string {
+	if args.ConfigFile != "" {
+		return []string{args.ConfigFile}
+	}
+	project := ""
+	if dir, err := os.Getwd(); err == nil {
+		project = findProjectConfig(dir)
+	}
+	return projectConfigFiles(project)
+}
+
+// allowedConfigFiles returns configFiles, provided a discovered project
+// config has been allowed (see checkAllowed). A --config file and the global
+// config are the user's own and need no allowing.
+func allowedConfigFiles(args Args) ([]string, error) {
+	files := configFiles(args)
+	if project := files[len(files)-1]; args.ConfigFile == "" && project != globalConfigFile() {
+		if err := checkAllowed(project); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// projectConfigFiles returns the global config followed by the project
+// config, leaving out the global one when it does not exist. Without a
+// project config only the global config is used.
+func projectConfigFiles(project string) []string {
+	global, found := findConfigVariant(defaultFile)
+	if project == "" || sameFile(project, global) {
+		return []string{global}
+	}
+	if !found {
+		return []string{project}
+	}
+	return []string{global, project}
+}
+
+// globalConfigFile returns the global config in whichever format exists,
+// falling back to the JSON one.
+func globalConfigFile() string {
+	global, _ := findConfigVariant(defaultFile)
+	return global
+}
+
+// findConfigVariant returns the first existing file named like path with
+// one of the configExtensions. It returns path itself if there is none.
+func findConfigVariant(path string) (string, bool) {
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+	for _, extension := range configExtensions {
+		candidate := base + extension
+		if info, err := os.Stat(candidate); err == nil && info.Mode().IsRegular() {
+			return candidate, true
+		}
+	}
+	return path, false
+}
+
+func sameFile(a, b string) bool {
+	aInfo, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bInfo, err := os.Stat(b)
+	return err == nil && os.SameFile(aInfo, bInfo)
+}
+
+// findProjectConfig returns the nearest project config in dir or one of its
+// parents, or "" if there is none.
+func findProjectConfig(dir string) string {
+	for {
+		if candidate, ok := findConfigVariant(filepath.Join(dir, projectConfigName)); ok {
+			return candidate
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// buildFullConfig converts the config into the map handed to source.Init.
+func buildFullConfig(cfg *config.Config) map[string]interface{} {
+	fullConfig := make(map[string]interface{})
+	fullConfig["sources"] = cfg.Sources
+	fullConfig["storage"] = cfg.Storage
+	if len(cfg.SourceSequence) > 0 {
+		fullConfig["source_sequence"] = cfg.SourceSequence
+	}
+	if cfg.Concurrency > 0 {
+		fullConfig["concurrency"] = cfg.Concurrency
+	}
+	if cfg.Transform != nil {
+		fullConfig["transform"] = cfg.Transform
+	}
+	if cfg.CollisionPolicy != "" {
+		fullConfig["collision_policy"] = cfg.CollisionPolicy
+	}
+	return fullConfig
+}
+
+// resolveSecrets loads the secrets through the cache and applies the global
+// and command-line filters. The cache always holds the unfiltered secrets,
+// so changing a filter never requires a refetch. stale reports whether
+// expired cached secrets were returned because fetching failed.
+func resolveSecrets(cfg *config.Config, stor storage.Storage, args Args) (secrets *secret.Secrets, stale bool, err error) {
+	secrets, stale, err = loadSecrets(cfg, stor, args, "")
+	if err != nil {
+		return nil, false, err
+	}
+
+	secrets, err = applyFilters(cfg, args, secrets)
+	if err != nil {
+		return nil, false, err
+	}
+	return secrets, stale, nil
+}
+
+// applyFilters applies the command-line filters and the global filter.
+func applyFilters(cfg *config.Config, args Args, secrets *secret.Secrets) (*secret.Secrets, error) {
+	rules := []filter.Config{{Include: args.Only, Exclude: args.Exclude}}
+	if cfg.Filter != nil {
+		rules = append(rules, *cfg.Filter)
+	}
+	for _, rule := range rules {
+		compiled, err := rule.Compile()
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter: %w", err)
+		}
+		secrets = compiled.Apply(secrets)
+	}
+	return secrets, nil
+}
+
+// loadSecrets returns the cached secrets when every source is still fresh.
+// Otherwise it fetches only the expired sources, reusing the cached entries
+// of the others, and refreshes the cache. If that fetch fails and the cache
+// expired within the stale_if_error window, the expired cache is returned
+// with stale set.
+//
+// When key is set and the cache records which source supplied it, only that
+// source matters: the cache is used while it is fresh, and otherwise only
+// that source is fetched again.
+func loadSecrets(cfg *config.Config, stor storage.Storage, args Args, key string) (*secret.Secrets, bool, error) {
+	pipeline, err := source.NewPipeline(buildFullConfig(cfg))
+	if err != nil {
+		return nil, false, fmt.Errorf("loading sources: %w", err)
+	}
+
+	if pipeline.Len() == 0 {
+		slog.Debug("No sources enabled, continuing with empty secrets")
+	}
+
+	var cached *secret.Secrets
+
+	// Check if we should use cached secrets
+	// The explain report is only available for a fresh fetch
+	if stor.HasCachedSecrets() && !args.Force && !args.Explain {
+		slog.Debug("Found cached secrets")
+		cached, err = stor.GetCachedSecrets()
+		if err != nil {
+			return nil, false, fmt.Errorf("getting cached secrets: %w", err)
+		}
+		registerValues(cached)
+
+		// Discard the cache if it was written for different sources
+		fingerprint := cfg.SourcesFingerprint()
+		if cached.Fingerprint != fingerprint {
+			slog.Debug("Discarding cached secrets, source config changed",
+				"cached", cached.Fingerprint, "current", fingerprint)
+			cached = nil
+		}
+	}
+
+	var origin []string
+	if cached != nil {
+		stale := pipeline.Stale(cached, args.TTL)
+		if meta, ok := cached.Meta(key); key != "" && ok && meta.Source != "" {
+			slog.Debug("Secret origin known from cache", "key", key, "source", meta.Source)
+			origin = []string{meta.Source}
+			if !slices.Contains(stale, meta.Source) {
+				stale = nil
+			}
+		}
+		if len(stale) == 0 {
+			return cached, false, nil
+		}
+		slog.Debug("Cached secrets expired", "sources", stale, "age", time.Since(cached.Timestamp))
+	}
+
+	slog.Debug("Fetching secrets from sources")
+	var secrets *secret.Secrets
+	var report *source.Report
+	if origin != nil {
+		secrets, report, err = pipeline.RefreshSources(cached, args.TTL, origin)
+	} else {
+		secrets, report, err = pipeline.Refresh(cached, args.TTL)
+	}
+	if err != nil {
+		if canServeStale(cfg, pipeline, cached, args, origin) {
+			slog.Warn("Fetching secrets failed, using expired cached secrets",
+				"age", time.Since(cached.OldestFetch()).Round(time.Second), "error", err)
+			return cached, true, nil
+		}
+		return nil, false, fmt.Errorf("getting secrets: %w", err)
+	}
+	if args.Explain {
+		writeExplainReport(stderr, report)
+	}
+	secrets.Fingerprint = cfg.SourcesFingerprint()
+
+	// Cache the secrets
+	if err := stor.CacheSecrets(secrets); err != nil {
+		return nil, false, fmt.Errorf("caching secrets: %w", err)
+	}
+
+	return secrets, false, nil
+}
+
+// registerValues makes sure the secret values never appear in logs or
+// error output.
+func registerValues(secrets *secret.Secrets) {
+	for _, value := range secrets.Entries {
+		redact.Register(value)
+	}
+}
+
+// canServeStale reports whether every expired source in cached expired
+// within the stale_if_error window. With origin set, only those sources are
+// served, so only they are checked.
+func canServeStale(cfg *config.Config, pipeline *source.Pipeline, cached *secret.Secrets, args Args, origin []string) bool {
+	window, err := cfg.StaleIfErrorWindow()
+	if err != nil || window == 0 || cached == nil {
+		return false
+	}
+	for _, name := range pipeline.StaleBeyond(cached, args.TTL, window) {
+		if origin == nil || slices.Contains(origin, name) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/napisani/secret_inject/internal/config"
+	"github.com/napisani/secret_inject/internal/filter"
+	"github.com/napisani/secret_inject/internal/secret"
+	"github.com/napisani/secret_inject/internal/source"
+	"github.com/napisani/secret_inject/internal/storage"
+)
+
+// memoryStorage is an in-memory storage.Storage for exercising the cache
+// logic without touching the keyring or temp files.
+type memoryStorage struct {
+	cached *secret.Secrets
+}
+
+func (m *memoryStorage) HasCachedSecrets() bool { return m.cached != nil }
+
+func (m *memoryStorage) GetCachedSecrets() (*secret.Secrets, error) { return m.cached, nil }
+
+func (m *memoryStorage) CacheSecrets(secrets *secret.Secrets) error {
+	m.cached = secrets
+	return nil
+}
+
+func (m *memoryStorage) CleanCachedSecrets() error {
+	m.cached = nil
+	return nil
+}
+
+func (m *memoryStorage) CleanAllCachedSecrets() error { return m.CleanCachedSecrets() }
+
+func newTestConfig() *config.Config {
+	return &config.Config{
+		Sources: map[string]interface{}{},
+		Storage: map[string]interface{}{"type": "file"},
+	}
+}
+
+func TestResolveSecretsUsesFreshCache(t *testing.T) {
+	cfg := newTestConfig()
+	cached := secret.New()
+	cached.Entries["API_KEY"] = "cached"
+	cached.Fingerprint = cfg.SourcesFingerprint()
+	stor := &memoryStorage{cached: cached}
+
+	secrets, _, err := resolveSecrets(cfg, stor, Args{TTL: time.Hour})
+	if err != nil {
+		t.Fatalf("resolveSecrets failed: %v", err)
+	}
+	if secrets.Entries["API_KEY"] != "cached" {
+		t.Fatalf("expected cached secrets to be used, got %v", secrets.Entries)
+	}
+}
+
+func TestResolveSecretsDiscardsCacheForChangedConfig(t *testing.T) {
+	cfg := newTestConfig()
+	cached := secret.New()
+	cached.Entries["API_KEY"] = "cached"
+	cached.Fingerprint = "written-by-another-config"
+	stor := &memoryStorage{cached: cached}
+
+	secrets, _, err := resolveSecrets(cfg, stor, Args{TTL: time.Hour})
+	if err != nil {
+		t.Fatalf("resolveSecrets failed: %v", err)
+	}
+	if _, ok := secrets.Entries["API_KEY"]; ok {
+		t.Fatalf("expected stale cache to be discarded, got %v", secrets.Entries)
+	}
+	if stor.cached.Fingerprint != cfg.SourcesFingerprint() {
+		t.Fatalf("expected refreshed cache to record the current fingerprint")
+	}
+}
+
+func TestEditedConfigRefetchesIntoSameNamespace(t *testing.T) {
+	calls := 0
+	withFakeCLI(t, "doppler", func(...string) ([]byte, error) {
+		calls++
+		return []byte(fmt.Sprintf(`{"DB_URL":{"computed":"postgres://fetch-%d"}}`, calls)), nil
+	})
+
+	cacheDir := t.TempDir()
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	writeConfig := func(env string) {
+		t.Helper()
+		content := `{
+  "sources": {"doppler": {"project": "proj", "env": "` + env + `"}},
+  "storage": {"type": "encrypted-file", "directory": "` + cacheDir + `", "password": "correct horse"}
+}`
+		if err := os.WriteFile(configFile, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	resolve := func() (*config.Config, storage.Storage, *secret.Secrets) {
+		t.Helper()
+		cfg, stor, err := setup(Args{ConfigFile: configFile})
+		if err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+		secrets, _, err := resolveSecrets(cfg, stor, Args{TTL: time.Hour})
+		if err != nil {
+			t.Fatalf("resolveSecrets failed: %v", err)
+		}
+		return cfg, stor, secrets
+	}
+
+	writeConfig("dev")
+	before, _, _ := resolve()
+	if _, _, secrets := resolve(); calls != 1 || secrets.Entries["DB_URL"] != "postgres://fetch-1" {
+		t.Fatalf("expected the second run to use the cache, got %d fetches and %v", calls, secrets.Entries)
+	}
+
+	writeConfig("stg")
+	after, stor, secrets := resolve()
+	if calls != 2 || secrets.Entries["DB_URL"] != "postgres://fetch-2" {
+		t.Fatalf("expected the edited config to refetch, got %d fetches and %v", calls, secrets.Entries)
+	}
+	if before.Namespace() != after.Namespace() {
+		t.Fatalf("expected editing the config to keep namespace %s, got %s", before.Namespace(), after.Namespace())
+	}
+	namespaces, err := stor.(storage.Inspector).Namespaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(namespaces) != 1 || namespaces[0] != after.Namespace() {
+		t.Fatalf("expected a single cache in namespace %s, got %v", after.Namespace(), namespaces)
+	}
+}
+
+func TestResolveSecretsAppliesGlobalAndFlagFilters(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.Filter = &filter.Config{Exclude: []string{"prefix:DOPPLER_"}}
+
+	cached := secret.New()
+	cached.Entries["DOPPLER_CONFIG"] = "dev"
+	cached.Entries["DB_PASSWORD"] = "pw"
+	cached.Entries["DB_USER"] = "user"
+	cached.Entries["API_KEY"] = "key"
+	cached.Fingerprint = cfg.SourcesFingerprint()
+	stor := &memoryStorage{cached: cached}
+
+	args := Args{TTL: time.Hour, Only: stringList{"DB_*"}, Exclude: stringList{"DB_USER"}}
+	secrets, _, err := resolveSecrets(cfg, stor, args)
+	if err != nil {
+		t.Fatalf("resolveSecrets failed: %v", err)
+	}
+	if len(secrets.Entries) != 1 || secrets.Entries["DB_PASSWORD"] != "pw" {
+		t.Fatalf("unexpected secrets: %v", secrets.Entries)
+	}
+	if len(stor.cached.Entries) != 4 {
+		t.Fatalf("filters must not modify the cache")
+	}
+}
+
+// withDefaultFile points the global config at path for the test.
+func withDefaultFile(t *testing.T, path string) {
+	t.Helper()
+	original := defaultFile
+	defaultFile = path
+	t.Cleanup(func() { defaultFile = original })
+}
+
+// withWorkingDir changes into dir for the test.
+func withWorkingDir(t *testing.T, dir string) {
+	t.Helper()
+	original, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(original) })
+}
+
+func TestConfigFilesLayersProjectOnGlobal(t *testing.T) {
+	global := filepath.Join(t.TempDir(), "global.json")
+	if err := os.WriteFile(global, []byte(`{"storage": {"type": "file"}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	withDefaultFile(t, global)
+
+	root := t.TempDir()
+	project := filepath.Join(root, projectConfigName)
+	if err := os.WriteFile(project, []byte(`{"sources": {}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	sub := filepath.Join(root, "src", "app")
+	if err := os.MkdirAll(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	withWorkingDir(t, sub)
+
+	// The working directory may be reported through a symlink.
+	if files := configFiles(Args{}); len(files) != 2 || files[0] != global || !sameFile(files[1], project) {
+		t.Fatalf("expected global and project config, got %v", files)
+	}
+	if files := configFiles(Args{ConfigFile: "explicit.json"}); len(files) != 1 || files[0] != "explicit.json" {
+		t.Fatalf("expected --config to take precedence, got %v", files)
+	}
+
+	withDefaultFile(t, filepath.Join(t.TempDir(), "missing.json"))
+	if files := configFiles(Args{}); len(files) != 1 || !sameFile(files[0], project) {
+		t.Fatalf("expected only the project config without a global one, got %v", files)
+	}
+
+	withWorkingDir(t, t.TempDir())
+	if files := configFiles(Args{}); len(files) != 1 || files[0] != defaultFile {
+		t.Fatalf("expected the global config outside a project, got %v", files)
+	}
+}
+
+func TestSetupRequiresAllowedProjectConfig(t *testing.T) {
+	withDefaultFile(t, filepath.Join(t.TempDir(), "missing.json"))
+	root := t.TempDir()
+	content := `{"sources": {}, "storage": {"type": "file"}}`
+	if err := os.WriteFile(filepath.Join(root, projectConfigName), []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	withWorkingDir(t, root)
+
+	if _, _, err := setup(Args{}); !errors.Is(err, errNotAllowed) {
+		t.Fatalf("expected a discovered config to need allowing, got %v", err)
+	}
+	project, _ := os.Getwd()
+	project = findProjectConfig(project)
+	if _, err := allowConfig(project); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := setup(Args{}); err != nil {
+		t.Fatalf("expected the allowed config to be used, got %v", err)
+	}
+
+	if err := os.WriteFile(project, []byte(content+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := setup(Args{}); !errors.Is(err, errNotAllowed) {
+		t.Fatalf("expected an edited config to need allowing again, got %v", err)
+	}
+	if _, _, err := setup(Args{ConfigFile: project}); err != nil {
+		t.Fatalf("expected --config to need no allowing, got %v", err)
+	}
+}
+
+func TestSetupAppliesProfile(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	content := `{
+  "sources": {"doppler": {"project": "proj", "env": "dev"}},
+  "storage": {"type": "file"},
+  "profiles": {"prod": {"sources": {"doppler": {"env": "prd"}}}}
+}`
+	if err := os.WriteFile(configFile, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(profileEnvVar, "prod")
+	cfg, _, err := setup(Args{ConfigFile: configFile})
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	if env := cfg.Sources["doppler"].(map[string]interface{})["env"]; env != "prd" || cfg.Profile() != "prod" {
+		t.Errorf("expected the profile from %s, got env %v", profileEnvVar, env)
+	}
+
+	if _, _, err := setup(Args{ConfigFile: configFile, Profile: "qa"}); err == nil || !strings.Contains(err.Error(), `"qa"`) {
+		t.Errorf("expected --profile to take precedence and fail for an unknown profile, got %v", err)
+	}
+}
+
+// fakeCLI answers the commands run through a stubbed source CLI.
+type fakeCLI func(args ...string) ([]byte, error)
+
+// fakeCLIs holds the CLIs installed by withFakeCLI for the current test.
+var fakeCLIs map[string]fakeCLI
+
+// withFakeCLI installs cli as the source CLI called name for the rest of the
+// test. CLIs that were not installed this way are reported as missing.
+func withFakeCLI(t *testing.T, name string, cli fakeCLI) {
+	t.Helper()
+	if fakeCLIs == nil {
+		fakeCLIs = make(map[string]fakeCLI)
+		restore := source.StubCommands(func(_ context.Context, name string, _ []string, _ string, args ...string) ([]byte, error) {
+			return fakeCLIs[name](args...)
+		}, func(name string) (string, error) {
+			if _, ok := fakeCLIs[name]; !ok {
+				return "", &exec.Error{Name: name, Err: exec.ErrNotFound}
+			}
+			return "/usr/bin/" + name, nil
+		})
+		t.Cleanup(func() {
+			restore()
+			fakeCLIs = nil
+		})
+	}
+	fakeCLIs[name] = cli
+}
+
+// respond returns a fakeCLI that prints output for every command.
+func respond(output string) fakeCLI {
+	return func(...string) ([]byte, error) { return []byte(output), nil }
+}
+
+// fail returns a fakeCLI that fails every command with message.
+func fail(message string) fakeCLI {
+	return func(...string) ([]byte, error) { return nil, errors.New(message) }
+}
+
+// withFailingDoppler makes doppler the only installed source CLI, with
+// every command failing.
+func withFailingDoppler(t *testing.T) {
+	t.Helper()
+	withFakeCLI(t, "doppler", fail("Unable to reach Doppler"))
+}
+
+func newDopplerCache(cfg *config.Config, fetchedAt time.Time) *secret.Secrets {
+	cached := secret.New()
+	cached.Set("DB_URL", "postgres://cached", "proj/dev")
+	cached.Stamp("doppler", fetchedAt)
+	cached.Fetches["doppler"] = secret.Fetch{FetchedAt: fetchedAt, Keys: []string{"DB_URL"}}
+	cached.Fingerprint = cfg.SourcesFingerprint()
+	return cached
+}
+
+func TestResolveSecretsServesStaleCacheOnError(t *testing.T) {
+	withFailingDoppler(t)
+
+	tests := []struct {
+		name         string
+		staleIfError string
+		age          time.Duration
+		wantStale    bool
+	}{
+		{name: "within window", staleIfError: "24h", age: 2 * time.Hour, wantStale: true},
+		{name: "beyond window", staleIfError: "24h", age: 48 * time.Hour},
+		{name: "not configured", age: 2 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig()
+			cfg.Sources = map[string]interface{}{
+				"doppler": map[string]interface{}{"project": "proj", "env": "dev"},
+			}
+			cfg.StaleIfError = tt.staleIfError
+			stor := &memoryStorage{cached: newDopplerCache(cfg, time.Now().Add(-tt.age))}
+
+			secrets, stale, err := resolveSecrets(cfg, stor, Args{TTL: time.Hour})
+			if !tt.wantStale {
+				if err == nil {
+					t.Fatalf("expected fetch error, got secrets %v (stale %v)", secrets.Entries, stale)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveSecrets failed: %v", err)
+			}
+			if !stale {
+				t.Fatalf("expected stale secrets to be reported")
+			}
+			if secrets.Entries["DB_URL"] != "postgres://cached" {
+				t.Fatalf("expected expired cache to be served, got %v", secrets.Entries)
+			}
+		})
+	}
+}
+
+func TestStringListSplitsCommas(t *testing.T) {
+	var list stringList
+	if err := list.Set("DB_*, API_KEY"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := list.Set("prefix:AWS_"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	want := []string{"DB_*", "API_KEY", "prefix:AWS_"}
+	if len(list) != len(want) {
+		t.Fatalf("got %v, want %v", list, want)
+	}
+	for i := range want {
+		if list[i] != want[i] {
+			t.Fatalf("got %v, want %v", list, want)
+		}
+	}
+}
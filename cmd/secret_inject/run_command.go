@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/napisani/secret_inject/internal/secret"
+)
+
+const runUsage = "usage: secret_inject run [flags] -- <command> [args...]"
+
+// Exit codes used when the child never ran, mirroring POSIX shells.
+const (
+	exitCommandNotRunnable = 126
+	exitCommandNotFound    = 127
+)
+
+var forwardedSignals = []os.Signal{os.Interrupt, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT}
+
+// runRunCommand resolves secrets and executes the given command with them
+// merged into its environment. It returns the exit code for the process.
+func runRunCommand(argv []string) int {
+	var args Args
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	registerFetchFlags(fs, &args)
+	if err := fs.Parse(argv); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		fmt.Fprintln(stderr, "Error:", err)
+		return 1
+	}
+
+	command := fs.Args()
+	if len(command) == 0 {
+		fmt.Fprintln(stderr, "Error:", runUsage)
+		return 1
+	}
+
+	configureLogging(args)
+
+	cfg, stor, err := setup(args)
+	if err != nil {
+		logSetupError(err)
+		return 1
+	}
+
+	// The command's own exit status is more useful than exitStaleSecrets
+	// here; the warning already tells the user the secrets are stale.
+	secrets, _, err := resolveSecrets(cfg, stor, args)
+	if err != nil {
+		slog.Error("Error resolving secrets", "error", err)
+		return 1
+	}
+
+	return runWithSecrets(command[0], command[1:], secrets)
+}
+
+// runWithSecrets starts the command with the secrets merged into the current
+// environment, forwards termination signals to it and waits for it to exit.
+func runWithSecrets(name string, args []string, secrets *secret.Secrets) int {
+	cmd := exec.Command(name, args...)
+	cmd.Env = mergeEnv(os.Environ(), secrets)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	// Subscribe before starting so a signal arriving during startup is not
+	// handled by the default action, which would orphan the child.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, forwardedSignals...)
+	defer signal.Stop(signals)
+
+	slog.Debug("Running command", "command", name, "secrets", len(secrets.Entries))
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintln(stderr, "Error:", err)
+		if errors.Is(err, exec.ErrNotFound) || errors.Is(err, os.ErrNotExist) {
+			return exitCommandNotFound
+		}
+		return exitCommandNotRunnable
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				slog.Debug("Forwarding signal", "signal", sig)
+				if err := cmd.Process.Signal(sig); err != nil {
+					slog.Debug("Failed to forward signal", "signal", sig, "error", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	err := cmd.Wait()
+	close(done)
+	return exitStatus(err)
+}
+
+// mergeEnv returns base with every secret set, replacing any existing
+// variable of the same name.
+func mergeEnv(base []string, secrets *secret.Secrets) []string {
+	env := make([]string, 0, len(base)+len(secrets.Entries))
+	for _, entry := range base {
+		name, _, _ := strings.Cut(entry, "=")
+		if _, overridden := secrets.Entries[name]; overridden {
+			continue
+		}
+		env = append(env, entry)
+	}
+
+	keys := make([]string, 0, len(secrets.Entries))
+	for key := range secrets.Entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		env = append(env, key+"="+secrets.Entries[key])
+	}
+	return env
+}
+
+// exitStatus converts the result of cmd.Wait into a process exit code. A
+// child killed by a signal is reported as 128+signal, as shells do.
+func exitStatus(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		fmt.Fprintln(stderr, "Error:", err)
+		return 1
+	}
+
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return exitErr.ExitCode()
+}
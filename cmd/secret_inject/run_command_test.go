@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os/exec"
+	"runtime"
+	"testing"
+
+	"github.com/napisani/secret_inject/internal/secret"
+)
+
+func TestMergeEnvOverridesExistingValues(t *testing.T) {
+	secrets := secret.New()
+	secrets.Entries["API_KEY"] = "from-secret"
+	secrets.Entries["NEW_KEY"] = "added"
+
+	env := mergeEnv([]string{"PATH=/bin", "API_KEY=from-parent"}, secrets)
+
+	want := []string{"PATH=/bin", "API_KEY=from-secret", "NEW_KEY=added"}
+	if len(env) != len(want) {
+		t.Fatalf("mergeEnv returned %v, want %v", env, want)
+	}
+	for i := range want {
+		if env[i] != want[i] {
+			t.Fatalf("mergeEnv()[%d] = %q, want %q", i, env[i], want[i])
+		}
+	}
+}
+
+func TestRunWithSecretsPropagatesExitCode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires a POSIX shell")
+	}
+
+	secrets := secret.New()
+	secrets.Entries["EXPECTED_CODE"] = "7"
+
+	code := runWithSecrets("sh", []string{"-c", `exit "$EXPECTED_CODE"`}, secrets)
+	if code != 7 {
+		t.Fatalf("expected exit code 7, got %d", code)
+	}
+}
+
+func TestRunWithSecretsReportsMissingCommand(t *testing.T) {
+	if _, err := exec.LookPath("secret-inject-missing-binary"); err == nil {
+		t.Skip("unexpected binary on PATH")
+	}
+
+	code := runWithSecrets("secret-inject-missing-binary", nil, secret.New())
+	if code != exitCommandNotFound {
+		t.Fatalf("expected exit code %d, got %d", exitCommandNotFound, code)
+	}
+}
+
+func TestRunWithSecretsReportsSignalExit(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires POSIX signals")
+	}
+
+	code := runWithSecrets("sh", []string{"-c", "kill -TERM $$"}, secret.New())
+	if code != 128+15 {
+		t.Fatalf("expected exit code %d, got %d", 128+15, code)
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/napisani/secret_inject/internal/config"
+	"github.com/napisani/secret_inject/internal/secret"
+	"github.com/napisani/secret_inject/internal/source"
+	"github.com/napisani/secret_inject/internal/storage"
+)
+
+const statusUsage = "usage: secret_inject status [flags]"
+
+// statusReport describes the cache of a config without any secret values.
+type statusReport struct {
+	ConfigFile    string
+	Profile       string
+	Namespace     string
+	Storage       *storage.Info
+	Namespaces    []string
+	NamespacesErr error
+	Cache         cacheStatus
+	Sources       []sourceStatus
+}
+
+type cacheStatus struct {
+	Present       bool
+	Err           error
+	Entries       int
+	Age           time.Duration
+	TTL           time.Duration
+	ConfigChanged bool
+}
+
+// sourceStatus extends source.Status with the cache state of the source.
+// Its TTL is the effective one, falling back to --ttl.
+type sourceStatus struct {
+	source.Status
+	TTL       time.Duration
+	FetchedAt time.Time
+	Keys      int
+	Fresh     bool
+}
+
+// runStatusCommand prints the state of the cache for the config. It never
+// fetches secrets. It returns the exit code for the process.
+func runStatusCommand(argv []string) int {
+	var args Args
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	registerFetchFlags(fs, &args)
+	if err := fs.Parse(argv); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		fmt.Fprintln(stderr, "Error:", err)
+		return 1
+	}
+	if fs.NArg() != 0 {
+		fmt.Fprintln(stderr, "Error:", statusUsage)
+		return 1
+	}
+
+	configureLogging(args)
+
+	cfg, stor, err := setup(args)
+	if err != nil {
+		logSetupError(err)
+		return 1
+	}
+
+	writeStatus(os.Stdout, collectStatus(cfg, stor, args))
+	return 0
+}
+
+func collectStatus(cfg *config.Config, stor storage.Storage, args Args) statusReport {
+	report := statusReport{
+		ConfigFile: strings.Join(cfg.Files(), " + "),
+		Profile:    cfg.Profile(),
+		Namespace:  cfg.Namespace(),
+		Cache:      cacheStatus{TTL: args.TTL},
+	}
+
+	if inspector, ok := stor.(storage.Inspector); ok {
+		info := inspector.Info()
+		report.Storage = &info
+		report.Namespaces, report.NamespacesErr = inspector.Namespaces()
+	}
+
+	fullConfig := buildFullConfig(cfg)
+	// A source that fails to initialize is reported by source.Statuses, so
+	// the pipeline is only used to judge freshness when it loads.
+	pipeline, _ := source.NewPipeline(fullConfig)
+
+	var cached *secret.Secrets
+	var stale []string
+	if stor.HasCachedSecrets() {
+		report.Cache.Present = true
+		cached, report.Cache.Err = stor.GetCachedSecrets()
+	}
+	if cached != nil {
+		registerValues(cached)
+		report.Cache.Entries = len(cached.Entries)
+		report.Cache.Age = time.Since(cached.OldestFetch())
+		report.Cache.ConfigChanged = cached.Fingerprint != cfg.SourcesFingerprint()
+		if pipeline != nil {
+			stale = pipeline.Stale(cached, args.TTL)
+		}
+	}
+
+	for _, status := range source.Statuses(fullConfig) {
+		entry := sourceStatus{Status: status, TTL: args.TTL}
+		if status.TTL != 0 {
+			entry.TTL = status.TTL
+		}
+		if fetch, ok := cachedFetch(cached, status.Name); ok {
+			entry.FetchedAt = fetch.FetchedAt
+			entry.Keys = len(fetch.Keys)
+			entry.Fresh = pipeline != nil && !report.Cache.ConfigChanged && !slices.Contains(stale, status.Name)
+		}
+		report.Sources = append(report.Sources, entry)
+	}
+
+	return report
+}
+
+func cachedFetch(cached *secret.Secrets, name string) (secret.Fetch, bool) {
+	if cached == nil {
+		return secret.Fetch{}, false
+	}
+	fetch, ok := cached.Fetches[name]
+	return fetch, ok
+}
+
+func writeStatus(w io.Writer, report statusReport) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Config:\t%s\n", report.ConfigFile)
+	if report.Profile != "" {
+		fmt.Fprintf(tw, "Profile:\t%s\n", report.Profile)
+	}
+	fmt.Fprintf(tw, "Namespace:\t%s\n", report.Namespace)
+	if report.Storage != nil {
+		fmt.Fprintf(tw, "Storage:\t%s (%s)\n", report.Storage.Type, report.Storage.Location)
+		if report.Storage.Type == "keyring" {
+			fmt.Fprintf(tw, "Keyring backend:\t%s (available: %s)\n",
+				orDash(report.Storage.Backend), strings.Join(report.Storage.AvailableBackends, ", "))
+		}
+	}
+	fmt.Fprintf(tw, "Cache:\t%s\n", describeCache(report.Cache))
+	if report.Storage != nil {
+		fmt.Fprintf(tw, "Cached namespaces:\t%s\n", describeNamespaces(report))
+	}
+	tw.Flush()
+
+	if len(report.Sources) == 0 {
+		fmt.Fprintln(w, "\nNo sources configured")
+		return
+	}
+
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SOURCE\tSTATE\tTTL\tFETCHED\tKEYS\tCACHE")
+	var failures []sourceStatus
+	for _, entry := range report.Sources {
+		state := "enabled"
+		switch {
+		case entry.Err != nil:
+			state = "error"
+			failures = append(failures, entry)
+		case !entry.Enabled:
+			state = "disabled"
+		}
+
+		fetched, keyCount, cache := "-", "-", "missing"
+		if !entry.FetchedAt.IsZero() {
+			fetched = time.Since(entry.FetchedAt).Round(time.Second).String() + " ago"
+			keyCount = fmt.Sprint(entry.Keys)
+			cache = "expired"
+			if entry.Fresh {
+				cache = "fresh"
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", entry.Name, state, entry.TTL, fetched, keyCount, cache)
+	}
+	tw.Flush()
+
+	for _, entry := range failures {
+		fmt.Fprintf(w, "%s: %s\n", entry.Name, entry.Err)
+	}
+}
+
+func describeCache(cache cacheStatus) string {
+	switch {
+	case !cache.Present:
+		return "empty"
+	case cache.Err != nil:
+		return fmt.Sprintf("unreadable (%s)", cache.Err)
+	}
+
+	description := fmt.Sprintf("%d entries, oldest fetched %s ago (default TTL %s)",
+		cache.Entries, cache.Age.Round(time.Second), cache.TTL)
+	if cache.ConfigChanged {
+		description += ", written for a different source config"
+	}
+	return description
+}
+
+func describeNamespaces(report statusReport) string {
+	if report.NamespacesErr != nil {
+		return fmt.Sprintf("unavailable (%s)", report.NamespacesErr)
+	}
+	if len(report.Namespaces) == 0 {
+		return "none"
+	}
+
+	names := make([]string, 0, len(report.Namespaces))
+	for _, namespace := range report.Namespaces {
+		switch namespace {
+		case "":
+			namespace = "(legacy)"
+		case report.Namespace:
+			namespace += " (current)"
+		}
+		names = append(names, namespace)
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/napisani/secret_inject/internal/secret"
+	"github.com/napisani/secret_inject/internal/storage"
+)
+
+// inspectableStorage adds storage.Inspector to memoryStorage.
+type inspectableStorage struct {
+	memoryStorage
+	namespaces []string
+}
+
+func (s *inspectableStorage) Info() storage.Info {
+	return storage.Info{Type: "keyring", Location: "secret_inject-test", Backend: "file", AvailableBackends: []string{"file", "pass"}}
+}
+
+func (s *inspectableStorage) Namespaces() ([]string, error) { return s.namespaces, nil }
+
+func TestCollectStatus(t *testing.T) {
+	withFakeCLI(t, "doppler", fail("exit status 1"))
+
+	cfg := newTestConfig()
+	cfg.Sources = map[string]interface{}{
+		"doppler": map[string]interface{}{"project": "proj", "env": "dev", "ttl": "10m"},
+		"nope":    map[string]interface{}{},
+	}
+
+	fetchedAt := time.Now().Add(-5 * time.Minute)
+	cached := secret.New()
+	cached.Set("DB_URL", "postgres://cached", "proj/dev")
+	cached.Stamp("doppler", fetchedAt)
+	cached.Fetches["doppler"] = secret.Fetch{FetchedAt: fetchedAt, Keys: []string{"DB_URL"}}
+	cached.Fingerprint = cfg.SourcesFingerprint()
+	stor := &inspectableStorage{memoryStorage: memoryStorage{cached: cached}}
+	stor.namespaces = []string{"", "0123456789abcdef"}
+
+	report := collectStatus(cfg, stor, Args{ConfigFile: "test.json", TTL: time.Hour})
+
+	if !report.Cache.Present || report.Cache.Entries != 1 || report.Cache.ConfigChanged {
+		t.Fatalf("unexpected cache status %+v", report.Cache)
+	}
+	if report.Storage == nil || report.Storage.Backend != "file" {
+		t.Fatalf("expected storage info, got %+v", report.Storage)
+	}
+	if len(report.Sources) != 2 {
+		t.Fatalf("expected 2 sources, got %+v", report.Sources)
+	}
+
+	doppler, nope := report.Sources[0], report.Sources[1]
+	if !doppler.Enabled || doppler.Keys != 1 || doppler.TTL != 10*time.Minute {
+		t.Errorf("unexpected doppler status %+v", doppler)
+	}
+	if nope.Err == nil || nope.Enabled {
+		t.Errorf("expected unknown source to report an error, got %+v", nope)
+	}
+	// The broken source keeps the pipeline from loading, so freshness is
+	// unknown rather than guessed.
+	if doppler.Fresh {
+		t.Errorf("expected freshness to be unknown without a pipeline")
+	}
+
+	var buf bytes.Buffer
+	writeStatus(&buf, report)
+	output := buf.String()
+	for _, want := range []string{"Namespace:", "keyring (secret_inject-test)", "file (available: file, pass)", "1 entries", "(legacy)", "doppler", "nope: unknown source"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in status output:\n%s", want, output)
+		}
+	}
+	if strings.Contains(output, "postgres://cached") {
+		t.Fatalf("status must not print values:\n%s", output)
+	}
+}
+
+func TestCollectStatusFreshness(t *testing.T) {
+	withFakeCLI(t, "doppler", fail("exit status 1"))
+
+	cfg := newTestConfig()
+	cfg.Sources = map[string]interface{}{
+		"doppler": map[string]interface{}{"project": "proj", "env": "dev"},
+	}
+
+	for _, tt := range []struct {
+		age   time.Duration
+		fresh bool
+	}{
+		{age: 5 * time.Minute, fresh: true},
+		{age: 2 * time.Hour, fresh: false},
+	} {
+		cached := newDopplerCache(cfg, time.Now().Add(-tt.age))
+		report := collectStatus(cfg, &memoryStorage{cached: cached}, Args{TTL: time.Hour})
+		if got := report.Sources[0].Fresh; got != tt.fresh {
+			t.Errorf("age %v: fresh = %v, want %v", tt.age, got, tt.fresh)
+		}
+	}
+
+	report := collectStatus(cfg, &memoryStorage{}, Args{TTL: time.Hour})
+	if report.Cache.Present || !report.Sources[0].FetchedAt.IsZero() {
+		t.Errorf("expected an empty cache, got %+v", report)
+	}
+}
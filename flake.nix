@@ -43,7 +43,7 @@
           # remeber to bump this hash when your dependencies change.
           #vendorSha256 = pkgs.lib.fakeSha256;
 
-          vendorHash = "sha256-NzI/Ms98diZFHXeRdEE/XDlGaCOBtBQEogLxAuRZwQQ=";
+          vendorHash = "sha256-7GcrYHNGsgkSmfgXqW66pS+rauPeUGL68D7eIBqXIt8=";
         };
 
         devShells.default = pkgs.mkShell { buildInputs = devDeps; };
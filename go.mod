@@ -4,7 +4,10 @@ go 1.22
 
 require (
 	github.com/99designs/keyring v1.2.2
+	github.com/BurntSushi/toml v1.4.0
+	golang.org/x/crypto v0.24.0
 	golang.org/x/term v0.21.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
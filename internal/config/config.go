@@ -1,19 +1,66 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/napisani/secret_inject/internal/filter"
+	"github.com/napisani/secret_inject/internal/schema"
+	"github.com/napisani/secret_inject/internal/transform"
 )
 
+// Config is the config file. Its schema (see Schema) is derived from the
+// struct tags of Config and of the source and storage config types.
 type Config struct {
-	Sources        map[string]interface{} `json:"sources"`
-	Storage        map[string]interface{} `json:"storage"`
-	SourceSequence []string               `json:"source_sequence"`
+	// SchemaURL lets editors find the JSON Schema of the file.
+	SchemaURL      string                 `json:"$schema,omitempty" doc:"URL of this JSON Schema, for editors."`
+	Sources        map[string]interface{} `json:"sources" schema:"ref=sources" doc:"The secret sources to read, by name."`
+	Storage        map[string]interface{} `json:"storage" schema:"required,ref=storage" doc:"Where fetched secrets are cached."`
+	SourceSequence []string               `json:"source_sequence" schema:"nonempty" doc:"Sources fetched one after another, in this order, before the remaining sources are fetched in parallel."`
+	Concurrency    int                    `json:"concurrency,omitempty" schema:"min=0" doc:"How many CLI commands run at once (default 4)."`
+	Filter         *filter.Config         `json:"filter,omitempty" doc:"Selects which secrets are output."`
+	Transform      *transform.Config      `json:"transform,omitempty" doc:"Renames secrets after the per-source transforms."`
+	// CollisionPolicy decides which value wins when several sources provide
+	// the same secret: last-wins (default), first-wins, error or warn.
+	CollisionPolicy string `json:"collision_policy,omitempty" schema:"enum=last-wins|first-wins|error|warn" doc:"Which value wins when several sources provide the same secret."`
+	// StaleIfError is how long past its TTL a cache may still be served when
+	// fetching fresh secrets fails, as a duration such as "24h".
+	StaleIfError string `json:"stale_if_error,omitempty" schema:"duration" doc:"How long past its TTL a cache may still be served when fetching fails, such as \"24h\"."`
+	// Profiles are named variants of the config, such as dev, staging and
+	// prod, selected with ApplyProfile.
+	Profiles map[string]Profile `json:"profiles,omitempty" doc:"Named variants of the config, selected with --profile or SECRET_INJECT_PROFILE."`
+
+	// path is the file the config was read from, which scopes its cache
+	// namespace.
+	path string
+	// document is the decoded file, which keeps the settings the struct has
+	// no field for so Validate can report them.
+	document map[string]interface{}
+	// files lists the files a layered config was merged from.
+	files []string
+	// profile is the name of the applied profile, if any.
+	profile string
 }
 
+// Profile overrides parts of the config. Each entry in Sources is merged
+// into the source block of the same name setting by setting, so a profile
+// can change just the doppler "env"; a null entry removes the source.
+// SourceSequence, when set, replaces the base sequence.
+type Profile struct {
+	Sources        map[string]interface{} `json:"sources,omitempty" schema:"ref=profile_sources" doc:"Settings merged into the source blocks of the same name; null removes a source."`
+	SourceSequence []string               `json:"source_sequence,omitempty" schema:"nonempty" doc:"Replaces the base source_sequence."`
+}
+
+// ReadConfig reads a JSON, YAML or TOML config file (see toJSON).
 func ReadConfig(filename string) (*Config, error) {
 	slog.Debug("Reading config file", "filename", filename)
 
@@ -25,16 +72,142 @@ func ReadConfig(filename string) (*Config, error) {
 		return nil, err
 	}
 
+	normalized, err := toJSON(filename, content)
+	if err != nil {
+		return nil, err
+	}
+
 	var config Config
-	err = json.Unmarshal(content, &config)
+	err = json.Unmarshal(normalized, &config)
 	if err != nil {
 		return nil, err
 	}
+	if err := json.Unmarshal(normalized, &config.document); err != nil {
+		return nil, err
+	}
+
+	config.path = filename
+	if absPath, err := filepath.Abs(filename); err == nil {
+		config.path = absPath
+	}
 
 	slog.Debug("Read config contents", "sources", len(config.Sources), "storage", len(config.Storage))
 	return &config, nil
 }
 
+// Namespace identifies the cache belonging to this config. It is derived
+// from the absolute config path and the applied profile, so two configs or
+// profiles never share cached secrets. Editing the config keeps the
+// namespace; SourcesFingerprint tells whether the cached secrets still
+// match it.
+func (c *Config) Namespace() string {
+	hash := sha256.New()
+	hash.Write([]byte(c.path))
+	if c.profile != "" {
+		hash.Write([]byte{0})
+		hash.Write([]byte(c.profile))
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:16]
+}
+
+// SourcesFingerprint hashes the settings that determine which secrets are
+// fetched and how they are merged and named in the cache (sources,
+// source_sequence, the global transform and the collision policy). Storage
+// and other settings do not affect the fingerprint.
+func (c *Config) SourcesFingerprint() string {
+	// json.Marshal sorts map keys, so equal configs always encode the same.
+	encoded, err := json.Marshal(struct {
+		Sources         map[string]interface{} `json:"sources"`
+		SourceSequence  []string               `json:"source_sequence"`
+		Transform       *transform.Config      `json:"transform,omitempty"`
+		CollisionPolicy string                 `json:"collision_policy,omitempty"`
+	}{c.Sources, c.SourceSequence, c.Transform, c.CollisionPolicy})
+	if err != nil {
+		return ""
+	}
+	hash := sha256.Sum256(encoded)
+	return hex.EncodeToString(hash[:])
+}
+
+// inheritedSettings are the top-level settings a layered config takes from
+// its less specific files. They describe the machine rather than the
+// project; everything that decides which secrets are fetched belongs to the
+// most specific file alone.
+var inheritedSettings = map[string]bool{
+	"storage":     true,
+	"concurrency": true,
+}
+
+// ReadLayered reads a config made of several files, each overlaid on the
+// ones before it: the last file provides every setting it has, and the
+// earlier ones only the inheritedSettings it leaves out, so a project config
+// gets the global "storage" but never the global sources, source_sequence,
+// profiles or transforms. A single file is read exactly as ReadConfig does.
+func ReadLayered(filenames ...string) (*Config, error) {
+	if len(filenames) == 1 {
+		return ReadConfig(filenames[0])
+	}
+	if len(filenames) == 0 {
+		return nil, errors.New("no config files given")
+	}
+
+	merged := make(map[string]json.RawMessage)
+	for i, filename := range filenames {
+		slog.Debug("Reading config file", "filename", filename)
+		checkConfigPermissions(filename)
+
+		content, err := os.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		normalized, err := toJSON(filename, content)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+		var layer map[string]json.RawMessage
+		if err := json.Unmarshal(normalized, &layer); err != nil {
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+		last := i == len(filenames)-1
+		for key, value := range layer {
+			if last || inheritedSettings[key] {
+				merged[key] = value
+			}
+		}
+	}
+
+	content, err := json.Marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+	var config Config
+	if err := json.Unmarshal(content, &config); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &config.document); err != nil {
+		return nil, err
+	}
+
+	// The namespace is scoped to the most specific file.
+	last := filenames[len(filenames)-1]
+	config.path = last
+	if absPath, err := filepath.Abs(last); err == nil {
+		config.path = absPath
+	}
+	config.files = filenames
+
+	slog.Debug("Merged config files", "files", filenames, "sources", len(config.Sources), "storage", len(config.Storage))
+	return &config, nil
+}
+
+// Files returns the files the config was read from, least specific first.
+func (c *Config) Files() []string {
+	if c.files == nil && c.path != "" {
+		return []string{c.path}
+	}
+	return c.files
+}
+
 // checkConfigPermissions warns if config file has insecure permissions (debug mode only)
 func checkConfigPermissions(filename string) {
 	fileInfo, err := os.Stat(filename)
@@ -56,19 +229,150 @@ func checkConfigPermissions(filename string) {
 	}
 }
 
-func (c *Config) Validate() error {
-	if c.Storage == nil {
-		return errors.New("storage configuration is required")
+// ApplyProfile overlays the named profile on the config. An empty name
+// leaves the config unchanged.
+func (c *Config) ApplyProfile(name string) error {
+	if name == "" {
+		return nil
 	}
-
-	storageType, ok := c.Storage["type"].(string)
+	profile, ok := c.Profiles[name]
 	if !ok {
-		return errors.New("storage 'type' field is required and must be a string")
+		if len(c.Profiles) == 0 {
+			return fmt.Errorf("profile %q not found: the config defines no profiles", name)
+		}
+		names := make([]string, 0, len(c.Profiles))
+		for profileName := range c.Profiles {
+			names = append(names, profileName)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("profile %q not found (available: %s)", name, strings.Join(names, ", "))
 	}
 
-	if storageType != "keyring" && storageType != "file" {
-		return errors.New("storage type must be 'keyring' or 'file'")
+	sources := make(map[string]interface{}, len(c.Sources)+len(profile.Sources))
+	for sourceName, block := range c.Sources {
+		sources[sourceName] = block
+	}
+	for sourceName, override := range profile.Sources {
+		if override == nil {
+			delete(sources, sourceName)
+			continue
+		}
+		overrideBlock, ok := override.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("profile %s: source %s must be an object or null", name, sourceName)
+		}
+		merged := make(map[string]interface{})
+		if base, ok := sources[sourceName].(map[string]interface{}); ok {
+			for key, value := range base {
+				merged[key] = value
+			}
+		}
+		for key, value := range overrideBlock {
+			merged[key] = value
+		}
+		sources[sourceName] = merged
 	}
+	c.Sources = sources
 
+	if len(profile.SourceSequence) > 0 {
+		c.SourceSequence = profile.SourceSequence
+	}
+	c.profile = name
 	return nil
 }
+
+// Profile returns the name of the applied profile, or "" if none is.
+func (c *Config) Profile() string {
+	return c.profile
+}
+
+// StaleIfErrorWindow returns the parsed stale_if_error grace window, or 0
+// when it is not set.
+func (c *Config) StaleIfErrorWindow() (time.Duration, error) {
+	if c.StaleIfError == "" {
+		return 0, nil
+	}
+	window, err := time.ParseDuration(c.StaleIfError)
+	if err != nil {
+		return 0, fmt.Errorf("stale_if_error: %w", err)
+	}
+	if window < 0 {
+		return 0, errors.New("stale_if_error must not be negative")
+	}
+	return window, nil
+}
+
+// Validate checks the config against its schema (see Schema), using the
+// sources and source_sequence of the applied profile. Every problem found
+// is reported, as a schema.Errors giving the JSON path of each.
+func (c *Config) Validate() error {
+	document, err := c.effectiveDocument()
+	if err != nil {
+		return err
+	}
+	errs := Schema().Validate(document)
+
+	sources, _ := document["sources"].(map[string]interface{})
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sequence, _ := document["source_sequence"].([]interface{})
+	for i, entry := range sequence {
+		name, ok := entry.(string)
+		if _, configured := sources[name]; !ok || strings.TrimSpace(name) == "" || configured {
+			continue
+		}
+		message := fmt.Sprintf("source %q is not configured", name)
+		if suggestion := schema.Suggest(name, names); suggestion != "" {
+			message += fmt.Sprintf(" (did you mean %q?)", suggestion)
+		}
+		errs = append(errs, schema.Error{Path: fmt.Sprintf("$.source_sequence[%d]", i), Message: message})
+	}
+
+	profileNames := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		profileNames = append(profileNames, name)
+	}
+	sort.Strings(profileNames)
+	for _, name := range profileNames {
+		if strings.TrimSpace(name) == "" {
+			errs = append(errs, schema.Error{Path: schema.Join("$.profiles", name), Message: "profile names must not be empty"})
+		}
+	}
+
+	return errs.Err()
+}
+
+// effectiveDocument returns the config as decoded from its files, or from
+// the struct if it was not read from one, with the sources and
+// source_sequence replaced by those in effect.
+func (c *Config) effectiveDocument() (map[string]interface{}, error) {
+	document := make(map[string]interface{}, len(c.document))
+	if c.document != nil {
+		for key, value := range c.document {
+			document[key] = value
+		}
+	} else {
+		encoded, err := json.Marshal(c)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(encoded, &document); err != nil {
+			return nil, err
+		}
+	}
+	document["sources"] = c.Sources
+	document["source_sequence"] = c.SourceSequence
+
+	// Round trip the document so every value has the type JSON decodes to.
+	encoded, err := json.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+	var normalized map[string]interface{}
+	if err := json.Unmarshal(encoded, &normalized); err != nil {
+		return nil, err
+	}
+	return normalized, nil
+}
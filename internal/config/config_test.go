@@ -1,9 +1,14 @@
 package config
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/napisani/secret_inject/internal/schema"
 )
 
 func TestReadConfig(t *testing.T) {
@@ -89,6 +94,24 @@ func TestValidate(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "valid stale_if_error",
+			config: &Config{
+				Sources:      map[string]interface{}{},
+				Storage:      map[string]interface{}{"type": "file"},
+				StaleIfError: "24h",
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid stale_if_error",
+			config: &Config{
+				Sources:      map[string]interface{}{},
+				Storage:      map[string]interface{}{"type": "file"},
+				StaleIfError: "a day",
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -122,3 +145,441 @@ func TestReadConfigInvalidJSON(t *testing.T) {
 		t.Error("Expected error for invalid JSON")
 	}
 }
+
+func TestNamespaceDependsOnPathOnly(t *testing.T) {
+	tmpDir := t.TempDir()
+	content := `{"storage": {"type": "file"}}`
+
+	write := func(name string, data string) *Config {
+		t.Helper()
+		configFile := filepath.Join(tmpDir, name)
+		if err := os.WriteFile(configFile, []byte(data), 0600); err != nil {
+			t.Fatalf("Failed to create test config: %v", err)
+		}
+		cfg, err := ReadConfig(configFile)
+		if err != nil {
+			t.Fatalf("ReadConfig failed: %v", err)
+		}
+		return cfg
+	}
+
+	projA := write("projA.json", content)
+	projB := write("projB.json", content)
+	if projA.Namespace() == projB.Namespace() {
+		t.Fatalf("expected different namespaces for different paths")
+	}
+
+	again := write("projA.json", content)
+	if projA.Namespace() != again.Namespace() {
+		t.Fatalf("expected stable namespace for unchanged config")
+	}
+
+	changed := write("projA.json", `{"storage": {"type": "keyring"}}`)
+	if projA.Namespace() != changed.Namespace() {
+		t.Fatalf("expected namespace to survive edits to the config")
+	}
+}
+
+func TestSourcesFingerprint(t *testing.T) {
+	base := &Config{
+		Sources: map[string]interface{}{
+			"doppler": map[string]interface{}{"project": "p", "env": "dev"},
+		},
+		Storage: map[string]interface{}{"type": "file"},
+	}
+	reordered := &Config{
+		Sources: map[string]interface{}{
+			"doppler": map[string]interface{}{"env": "dev", "project": "p"},
+		},
+		Storage: map[string]interface{}{"type": "keyring"},
+	}
+	changed := &Config{
+		Sources: map[string]interface{}{
+			"doppler": map[string]interface{}{"project": "p", "env": "prd"},
+		},
+		Storage: map[string]interface{}{"type": "file"},
+	}
+	sequenced := &Config{
+		Sources:        base.Sources,
+		Storage:        base.Storage,
+		SourceSequence: []string{"doppler"},
+	}
+
+	if base.SourcesFingerprint() != reordered.SourcesFingerprint() {
+		t.Errorf("fingerprint should ignore key order and storage settings")
+	}
+	if base.SourcesFingerprint() == changed.SourcesFingerprint() {
+		t.Errorf("fingerprint should change when a source changes")
+	}
+	if base.SourcesFingerprint() == sequenced.SourcesFingerprint() {
+		t.Errorf("fingerprint should change when source_sequence changes")
+	}
+}
+
+func TestReadLayered(t *testing.T) {
+	tmpDir := t.TempDir()
+	global := filepath.Join(tmpDir, "global.json")
+	project := filepath.Join(tmpDir, ".secret_inject.json")
+	globalContent := `{
+		"sources": {"doppler": {"project": "personal", "env": "dev"}},
+		"storage": {"type": "keyring"},
+		"concurrency": 2,
+		"stale_if_error": "24h"
+	}`
+	projectContent := `{
+		"sources": {"onepassword": {"secrets": {"TOKEN": "op://vault/item/field"}}}
+	}`
+	if err := os.WriteFile(global, []byte(globalContent), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(project, []byte(projectContent), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := ReadLayered(global, project)
+	if err != nil {
+		t.Fatalf("ReadLayered failed: %v", err)
+	}
+
+	if _, ok := cfg.Sources["doppler"]; ok || cfg.Sources["onepassword"] == nil {
+		t.Errorf("expected the project sources to replace the global ones, got %v", cfg.Sources)
+	}
+	if cfg.Storage["type"] != "keyring" || cfg.Concurrency != 2 {
+		t.Errorf("expected the global storage and concurrency to be kept, got %v and %d", cfg.Storage, cfg.Concurrency)
+	}
+	if cfg.StaleIfError != "" {
+		t.Errorf("expected only machine settings to be inherited, got stale_if_error %q", cfg.StaleIfError)
+	}
+	if files := cfg.Files(); len(files) != 2 || files[1] != project {
+		t.Errorf("unexpected files %v", files)
+	}
+
+	projectOnly, err := ReadConfig(project)
+	if err != nil {
+		t.Fatalf("ReadConfig failed: %v", err)
+	}
+	if projectOnly.Namespace() != cfg.Namespace() {
+		t.Errorf("expected the namespace to be scoped to the project file")
+	}
+
+	if err := os.WriteFile(project, []byte(`{"sources": `), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadLayered(global, project); err == nil || !strings.Contains(err.Error(), project) {
+		t.Errorf("expected the error to name the broken file, got %v", err)
+	}
+}
+
+func TestReadLayeredIgnoresGlobalSourceSettings(t *testing.T) {
+	tmpDir := t.TempDir()
+	global := filepath.Join(tmpDir, "global.json")
+	project := filepath.Join(tmpDir, ".secret_inject.json")
+	globalContent := `{
+		"sources": {
+			"doppler": {"project": "personal", "env": "dev"},
+			"onepassword": {"secrets": {"TOKEN": "op://vault/item/field"}}
+		},
+		"source_sequence": ["doppler", "onepassword"],
+		"collision_policy": "error",
+		"storage": {"type": "keyring"}
+	}`
+	projectContent := `{"sources": {"aws": {"secrets": {"API_KEY": "prod/api"}}}}`
+	if err := os.WriteFile(global, []byte(globalContent), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(project, []byte(projectContent), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := ReadLayered(global, project)
+	if err != nil {
+		t.Fatalf("ReadLayered failed: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected the project config to be valid on its own, got %v", err)
+	}
+	if cfg.SourceSequence != nil || cfg.CollisionPolicy != "" {
+		t.Errorf("expected no global source settings, got source_sequence %v and collision_policy %q", cfg.SourceSequence, cfg.CollisionPolicy)
+	}
+}
+
+func TestApplyProfile(t *testing.T) {
+	content := `{
+		"sources": {
+			"doppler": {"project": "app", "env": "dev"},
+			"onepassword": {"secrets": {"TOKEN": "op://dev/api/token"}},
+			"aws": {"secrets": {"DB_PASSWORD": "dev/db"}}
+		},
+		"source_sequence": ["doppler", "onepassword"],
+		"storage": {"type": "keyring"},
+		"profiles": {
+			"prod": {
+				"sources": {
+					"doppler": {"env": "prd"},
+					"aws": null
+				},
+				"source_sequence": ["onepassword", "doppler"]
+			},
+			"staging": {"sources": {"doppler": {"env": "stg"}}}
+		}
+	}`
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(configFile, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	read := func() *Config {
+		cfg, err := ReadConfig(configFile)
+		if err != nil {
+			t.Fatalf("ReadConfig failed: %v", err)
+		}
+		if err := cfg.Validate(); err != nil {
+			t.Fatalf("Validate failed: %v", err)
+		}
+		return cfg
+	}
+
+	base := read()
+	prod := read()
+	if err := prod.ApplyProfile("prod"); err != nil {
+		t.Fatalf("ApplyProfile failed: %v", err)
+	}
+
+	doppler := prod.Sources["doppler"].(map[string]interface{})
+	if doppler["project"] != "app" || doppler["env"] != "prd" {
+		t.Errorf("expected doppler env to be overridden, got %v", doppler)
+	}
+	if _, ok := prod.Sources["aws"]; ok {
+		t.Errorf("expected a null override to remove the source")
+	}
+	if prod.SourceSequence[0] != "onepassword" {
+		t.Errorf("expected the profile source_sequence, got %v", prod.SourceSequence)
+	}
+	if base.Sources["doppler"].(map[string]interface{})["env"] != "dev" {
+		t.Errorf("applying a profile must not change the base source blocks")
+	}
+	if prod.Profile() != "prod" || base.Profile() != "" {
+		t.Errorf("unexpected profile names %q and %q", prod.Profile(), base.Profile())
+	}
+
+	staging := read()
+	if err := staging.ApplyProfile("staging"); err != nil {
+		t.Fatalf("ApplyProfile failed: %v", err)
+	}
+	if base.Namespace() == prod.Namespace() || prod.Namespace() == staging.Namespace() {
+		t.Errorf("expected every profile to get its own namespace")
+	}
+
+	if err := read().ApplyProfile("qa"); err == nil || !strings.Contains(err.Error(), "available: prod, staging") {
+		t.Errorf("expected unknown profile error listing the profiles, got %v", err)
+	}
+}
+
+func TestValidateRejectsMalformedProfile(t *testing.T) {
+	cfg := &Config{
+		Storage:  map[string]interface{}{"type": "file"},
+		Profiles: map[string]Profile{"prod": {Sources: map[string]interface{}{"doppler": "prd"}}},
+	}
+	if err := cfg.Validate(); err == nil || err.Error() != "$.profiles.prod.sources.doppler: must be an object or null" {
+		t.Errorf("expected malformed profile error, got %v", err)
+	}
+}
+
+func TestReadConfigFormats(t *testing.T) {
+	files := map[string]string{
+		"config.json": `{
+			"source_sequence": ["doppler"],
+			"concurrency": 2,
+			"sources": {
+				"doppler": {"project": "app", "env": "dev"},
+				"onepassword": {"secrets": {"TOKEN": "op://vault/item/field"}}
+			},
+			"storage": {"type": "keyring", "allowed_backends": ["keychain"]}
+		}`,
+		"config.yaml": `
+# Why the token is needed
+source_sequence: [doppler]
+concurrency: 2
+sources:
+  doppler: {project: app, env: dev}
+  onepassword:
+    secrets:
+      TOKEN: op://vault/item/field
+storage:
+  type: keyring
+  allowed_backends: [keychain]
+`,
+		"config.toml": `
+# Why the token is needed
+source_sequence = ["doppler"]
+concurrency = 2
+
+[sources.doppler]
+project = "app"
+env = "dev"
+
+[sources.onepassword.secrets]
+TOKEN = "op://vault/item/field"
+
+[storage]
+type = "keyring"
+allowed_backends = ["keychain"]
+`,
+	}
+	// Files without a known extension are sniffed.
+	files["yaml-config"] = files["config.yaml"]
+	files["toml-config"] = files["config.toml"]
+
+	tmpDir := t.TempDir()
+	var want *Config
+	for _, name := range []string{"config.json", "config.yaml", "config.toml", "yaml-config", "toml-config"} {
+		path := filepath.Join(tmpDir, name)
+		if err := os.WriteFile(path, []byte(files[name]), 0600); err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := ReadConfig(path)
+		if err != nil {
+			t.Fatalf("ReadConfig(%s) failed: %v", name, err)
+		}
+		if err := cfg.Validate(); err != nil {
+			t.Fatalf("Validate(%s) failed: %v", name, err)
+		}
+		if want == nil {
+			want = cfg
+			continue
+		}
+		if cfg.SourcesFingerprint() != want.SourcesFingerprint() || !reflect.DeepEqual(cfg.Storage, want.Storage) || cfg.Concurrency != 2 {
+			t.Errorf("%s decoded differently from JSON: %+v", name, cfg)
+		}
+	}
+
+	broken := filepath.Join(tmpDir, "broken.yaml")
+	if err := os.WriteFile(broken, []byte("sources: [unclosed"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadConfig(broken); err == nil || !strings.Contains(err.Error(), "YAML") {
+		t.Errorf("expected a YAML parse error, got %v", err)
+	}
+}
+
+func TestReadLayeredMixesFormats(t *testing.T) {
+	tmpDir := t.TempDir()
+	global := filepath.Join(tmpDir, "global.toml")
+	project := filepath.Join(tmpDir, ".secret_inject.yaml")
+	if err := os.WriteFile(global, []byte("[storage]\ntype = \"file\"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	projectContent := `
+sources:
+  doppler: {project: app, env: dev}
+  aws: {secrets: {DB_PASSWORD: dev/db}}
+profiles:
+  prod:
+    sources:
+      aws: ~
+`
+	if err := os.WriteFile(project, []byte(projectContent), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := ReadLayered(global, project)
+	if err != nil {
+		t.Fatalf("ReadLayered failed: %v", err)
+	}
+	if cfg.Storage["type"] != "file" || cfg.Sources["doppler"] == nil {
+		t.Fatalf("unexpected merged config %+v", cfg)
+	}
+	if err := cfg.ApplyProfile("prod"); err != nil {
+		t.Fatalf("ApplyProfile failed: %v", err)
+	}
+	if _, ok := cfg.Sources["aws"]; ok {
+		t.Errorf("expected a YAML null to remove the source")
+	}
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	content := `
+source_sequence: [doppler, vualt]
+collision_policy: last-win
+concurrency: -1
+sources:
+  doppler: {projct: app, env: dev}
+  vault:
+    secrets: {DB_PASSWORD: secret/data/db}
+    auth_method: approle
+    retry: {backoff: 10s, max_backoff: 1s}
+  bitwarden:
+    secrets: {API_KEY: {project_id: abc}}
+  dopler: {}
+storage: {type: keyring, password: 42}
+transform: {invalid_names: drop}
+`
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configFile, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := ReadConfig(configFile)
+	if err != nil {
+		t.Fatalf("ReadConfig failed: %v", err)
+	}
+
+	var errs schema.Errors
+	if err := cfg.Validate(); !errors.As(err, &errs) {
+		t.Fatalf("expected schema errors, got %v", err)
+	}
+	var got []string
+	for _, err := range errs {
+		got = append(got, err.Error())
+	}
+	want := []string{
+		`$.sources.bitwarden.secrets.API_KEY: must include 'id' or non-empty 'key'`,
+		`$.sources.doppler.project: is required`,
+		`$.sources.doppler.projct: unknown field "projct" (did you mean "project"?)`,
+		`$.sources.vault.retry: 'max_backoff' must not be less than 'backoff'`,
+		`$.sources.vault.secrets.DB_PASSWORD: must have the form 'path#field'`,
+		`$.sources.dopler: unknown source "dopler" (did you mean "doppler"?)`,
+		`$.storage.password: must be a string`,
+		`$.concurrency: must be at least 0`,
+		`$.transform.invalid_names: must be one of "sanitize", "error"`,
+		`$.collision_policy: must be one of "last-wins", "first-wins", "error", "warn" (did you mean "last-wins"?)`,
+		`$.source_sequence[1]: source "vualt" is not configured (did you mean "vault"?)`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected errors:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestValidateUsesProfileSources(t *testing.T) {
+	cfg := &Config{
+		Sources: map[string]interface{}{"doppler": map[string]interface{}{"project": "app"}},
+		Storage: map[string]interface{}{"type": "file"},
+		Profiles: map[string]Profile{
+			"dev":    {Sources: map[string]interface{}{"doppler": map[string]interface{}{"env": "dev"}}},
+			"broken": {Sources: map[string]interface{}{"doppler": map[string]interface{}{"env": ""}}},
+		},
+	}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "$.sources.doppler.env: is required") {
+		t.Errorf("expected the base config to miss env, got %v", err)
+	}
+	if err := cfg.ApplyProfile("dev"); err != nil {
+		t.Fatal(err)
+	}
+	// Every profile is checked, not just the applied one.
+	if err := cfg.Validate(); err == nil || err.Error() != "$.profiles.broken.sources.doppler.env: must not be empty" {
+		t.Errorf("expected the profile to complete the source, got %v", err)
+	}
+}
+
+func TestPublishedSchemaIsCurrent(t *testing.T) {
+	published, err := os.ReadFile("../../schema/secret_inject.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := JSONSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(published) != string(current) {
+		t.Errorf("schema/secret_inject.schema.json is out of date; run: go run ./cmd/secret_inject config schema > schema/secret_inject.schema.json")
+	}
+}
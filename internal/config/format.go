@@ -0,0 +1,137 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Config file formats.
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+	FormatTOML = "toml"
+)
+
+// Formats lists the supported config file formats.
+var Formats = []string{FormatJSON, FormatYAML, FormatTOML}
+
+// Extensions maps each format to the file extension used for new files.
+var Extensions = map[string]string{
+	FormatJSON: ".json",
+	FormatYAML: ".yaml",
+	FormatTOML: ".toml",
+}
+
+// FormatForPath returns the format implied by the extension of filename, or
+// "" if the extension is not a known one.
+func FormatForPath(filename string) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		return FormatJSON
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".toml":
+		return FormatTOML
+	default:
+		return ""
+	}
+}
+
+// toJSON converts the content of a config file to JSON, so every format is
+// decoded into the same Config and handed to the sources the same way. The
+// format comes from the file extension; without a known one it is sniffed
+// from the content.
+func toJSON(filename string, content []byte) ([]byte, error) {
+	format := FormatForPath(filename)
+	if format == "" {
+		format = sniffFormat(content)
+	}
+
+	var document map[string]interface{}
+	switch format {
+	case FormatJSON:
+		return content, nil
+	case FormatYAML:
+		if err := yaml.Unmarshal(content, &document); err != nil {
+			return nil, fmt.Errorf("parsing YAML: %w", err)
+		}
+	case FormatTOML:
+		if err := toml.Unmarshal(content, &document); err != nil {
+			return nil, fmt.Errorf("parsing TOML: %w", err)
+		}
+	}
+
+	normalized, err := normalize(document)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(normalized)
+}
+
+// sniffFormat guesses the format of content: JSON if it is an object, TOML
+// if it parses as TOML, and YAML otherwise.
+func sniffFormat(content []byte) string {
+	if bytes.HasPrefix(bytes.TrimSpace(content), []byte("{")) {
+		return FormatJSON
+	}
+	var document map[string]interface{}
+	if toml.Unmarshal(content, &document) == nil {
+		return FormatTOML
+	}
+	return FormatYAML
+}
+
+// normalize converts a decoded YAML or TOML value into one that encodes as
+// JSON. YAML allows mappings with non-string keys, which JSON does not.
+func normalize(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			normalized, err := normalize(item)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+			v[key] = normalized
+		}
+		return v, nil
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			name, ok := key.(string)
+			if !ok {
+				return nil, errors.New("mapping keys must be strings")
+			}
+			normalized, err := normalize(item)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			converted[name] = normalized
+		}
+		return converted, nil
+	case []interface{}:
+		for i, item := range v {
+			normalized, err := normalize(item)
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %w", i, err)
+			}
+			v[i] = normalized
+		}
+		return v, nil
+	case []map[string]interface{}:
+		// TOML arrays of tables
+		converted := make([]interface{}, len(v))
+		for i, item := range v {
+			converted[i] = item
+		}
+		return normalize(converted)
+	default:
+		return v, nil
+	}
+}
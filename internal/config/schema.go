@@ -0,0 +1,44 @@
+package config
+
+import (
+	"sync"
+
+	"github.com/napisani/secret_inject/internal/schema"
+	"github.com/napisani/secret_inject/internal/source"
+	"github.com/napisani/secret_inject/internal/storage"
+)
+
+// SchemaID is where the JSON Schema of the config file is published.
+const SchemaID = "https://raw.githubusercontent.com/napisani/secret_inject/main/schema/secret_inject.schema.json"
+
+var (
+	schemaOnce   sync.Once
+	configSchema *schema.Schema
+)
+
+// Schema returns the schema of the config file. Each source block is
+// described by the config type its source registers, and a profile may
+// override any part of one.
+func Schema() *schema.Schema {
+	schemaOnce.Do(func() {
+		sourceSchemas := source.ConfigSchemas()
+		sources := make(map[string]*schema.Schema, len(sourceSchemas))
+		overrides := make(map[string]*schema.Schema, len(sourceSchemas))
+		for name, s := range sourceSchemas {
+			sources[name] = schema.Ref(name, s)
+			overrides[name] = s.Partial()
+		}
+		configSchema = schema.Of(Config{}, schema.Definitions{
+			"sources":         schema.Set("source", "", sources),
+			"profile_sources": schema.Set("source", "", overrides),
+			"storage":         schema.Of(storage.Config{}, nil),
+		})
+	})
+	return configSchema
+}
+
+// JSONSchema renders the schema of the config file as the JSON Schema
+// published at SchemaID.
+func JSONSchema() ([]byte, error) {
+	return schema.JSONSchema(Schema(), SchemaID, "secret_inject config")
+}
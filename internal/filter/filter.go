@@ -0,0 +1,138 @@
+package filter
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/napisani/secret_inject/internal/secret"
+)
+
+// Config is the serialized form of a set of filter rules. Each pattern is a
+// glob by default; the "prefix:", "glob:" and "regex:" markers select the
+// matching style explicitly.
+type Config struct {
+	Include []string `json:"include,omitempty" doc:"Patterns of secret names to keep; all secrets are kept when empty."`
+	Exclude []string `json:"exclude,omitempty" doc:"Patterns of secret names to drop."`
+}
+
+// Rules selects secrets by name. A secret is kept when it matches at least
+// one include pattern (or there are none) and no exclude pattern.
+type Rules struct {
+	include []matcher
+	exclude []matcher
+}
+
+type matcher func(name string) bool
+
+// Compile parses every pattern in the config.
+func (c Config) Compile() (*Rules, error) {
+	include, err := compilePatterns(c.Include)
+	if err != nil {
+		return nil, fmt.Errorf("include: %w", err)
+	}
+	exclude, err := compilePatterns(c.Exclude)
+	if err != nil {
+		return nil, fmt.Errorf("exclude: %w", err)
+	}
+	return &Rules{include: include, exclude: exclude}, nil
+}
+
+// Validate checks that every pattern compiles.
+func (c Config) Validate() error {
+	_, err := c.Compile()
+	return err
+}
+
+// FromRaw compiles rules from a decoded config block such as a source's
+// "filter" entry. A nil block yields empty rules.
+func FromRaw(raw interface{}) (*Rules, error) {
+	if raw == nil {
+		return &Rules{}, nil
+	}
+
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(encoded, &cfg); err != nil {
+		return nil, fmt.Errorf("filter must be an object with 'include' and 'exclude' lists: %w", err)
+	}
+	return cfg.Compile()
+}
+
+func compilePatterns(patterns []string) ([]matcher, error) {
+	matchers := make([]matcher, 0, len(patterns))
+	for _, pattern := range patterns {
+		m, err := compilePattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+	}
+	return matchers, nil
+}
+
+func compilePattern(pattern string) (matcher, error) {
+	switch {
+	case strings.HasPrefix(pattern, "prefix:"):
+		prefix := strings.TrimPrefix(pattern, "prefix:")
+		return func(name string) bool { return strings.HasPrefix(name, prefix) }, nil
+	case strings.HasPrefix(pattern, "regex:"):
+		re, err := regexp.Compile(strings.TrimPrefix(pattern, "regex:"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex pattern %q: %w", pattern, err)
+		}
+		return re.MatchString, nil
+	default:
+		glob := strings.TrimPrefix(pattern, "glob:")
+		if glob == "" {
+			return nil, fmt.Errorf("empty filter pattern")
+		}
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, fmt.Errorf("invalid glob pattern %q: %w", pattern, err)
+		}
+		return func(name string) bool {
+			matched, _ := path.Match(glob, name)
+			return matched
+		}, nil
+	}
+}
+
+// Empty reports whether the rules keep every secret.
+func (r *Rules) Empty() bool {
+	return r == nil || (len(r.include) == 0 && len(r.exclude) == 0)
+}
+
+// Match reports whether a secret with the given name is kept.
+func (r *Rules) Match(name string) bool {
+	if r.Empty() {
+		return true
+	}
+
+	if len(r.include) > 0 && !matchesAny(r.include, name) {
+		return false
+	}
+	return !matchesAny(r.exclude, name)
+}
+
+// Apply returns the secrets kept by the rules.
+func (r *Rules) Apply(secrets *secret.Secrets) *secret.Secrets {
+	if r.Empty() {
+		return secrets
+	}
+	return secrets.Filter(r.Match)
+}
+
+func matchesAny(matchers []matcher, name string) bool {
+	for _, m := range matchers {
+		if m(name) {
+			return true
+		}
+	}
+	return false
+}
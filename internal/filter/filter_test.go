@@ -0,0 +1,71 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/napisani/secret_inject/internal/secret"
+)
+
+func TestRulesMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		key     string
+		matched bool
+	}{
+		{"empty keeps everything", Config{}, "ANYTHING", true},
+		{"glob include", Config{Include: []string{"DB_*"}}, "DB_PASSWORD", true},
+		{"glob include miss", Config{Include: []string{"DB_*"}}, "API_KEY", false},
+		{"explicit glob", Config{Include: []string{"glob:API_?EY"}}, "API_KEY", true},
+		{"prefix exclude", Config{Exclude: []string{"prefix:DOPPLER_"}}, "DOPPLER_PROJECT", false},
+		{"prefix exclude miss", Config{Exclude: []string{"prefix:DOPPLER_"}}, "API_KEY", true},
+		{"regex include", Config{Include: []string{"regex:^(API|DB)_"}}, "DB_USER", true},
+		{"regex is unanchored", Config{Include: []string{"regex:TOKEN"}}, "GITHUB_TOKEN_RO", true},
+		{"exclude wins over include", Config{Include: []string{"*"}, Exclude: []string{"SECRET_*"}}, "SECRET_KEY", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := tt.config.Compile()
+			if err != nil {
+				t.Fatalf("Compile failed: %v", err)
+			}
+			if got := rules.Match(tt.key); got != tt.matched {
+				t.Errorf("Match(%q) = %v, want %v", tt.key, got, tt.matched)
+			}
+		})
+	}
+}
+
+func TestCompileRejectsInvalidPatterns(t *testing.T) {
+	for _, pattern := range []string{"regex:(", "glob:[", ""} {
+		if _, err := (Config{Include: []string{pattern}}).Compile(); err == nil {
+			t.Errorf("expected error for pattern %q", pattern)
+		}
+	}
+}
+
+func TestFromRaw(t *testing.T) {
+	rules, err := FromRaw(map[string]interface{}{
+		"exclude": []interface{}{"prefix:DOPPLER_"},
+	})
+	if err != nil {
+		t.Fatalf("FromRaw failed: %v", err)
+	}
+
+	secrets := secret.New()
+	secrets.Entries["DOPPLER_CONFIG"] = "dev"
+	secrets.Entries["API_KEY"] = "key"
+
+	filtered := rules.Apply(secrets)
+	if len(filtered.Entries) != 1 || filtered.Entries["API_KEY"] != "key" {
+		t.Fatalf("unexpected filtered secrets: %v", filtered.Entries)
+	}
+	if len(secrets.Entries) != 2 {
+		t.Fatalf("Apply must not modify its input")
+	}
+
+	if _, err := FromRaw("DB_*"); err == nil {
+		t.Fatalf("expected error for non-object filter")
+	}
+}
@@ -3,21 +3,29 @@ package output
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"log/slog"
+	"os"
+	"sort"
 	"strings"
 
 	"github.com/napisani/secret_inject/internal/secret"
+	"github.com/napisani/secret_inject/internal/transform"
 )
 
 func ExportShell(secrets *secret.Secrets) {
 	slog.Debug("Exporting secrets as shell commands")
+	writeShell(os.Stdout, secrets)
+}
+
+func writeShell(w io.Writer, secrets *secret.Secrets) {
 	var str strings.Builder
 
-	for key, value := range secrets.Entries {
-		escapedValue := escapeShellValue(value)
+	for _, key := range safeKeys(sortedKeys(secrets)) {
+		escapedValue := escapeShellValue(secrets.Entries[key])
 		str.WriteString(fmt.Sprintf("export %s='%s'\n", key, escapedValue))
 	}
-	fmt.Print(str.String())
+	fmt.Fprint(w, str.String())
 }
 
 // escapeShellValue escapes single quotes for use in single-quoted shell strings
@@ -48,8 +56,8 @@ func ExportEnv(secrets *secret.Secrets) {
 	slog.Debug("Exporting secrets as env file format")
 	var str strings.Builder
 
-	for key, value := range secrets.Entries {
-		escapedValue := escapeEnvValue(value)
+	for _, key := range safeKeys(sortedKeys(secrets)) {
+		escapedValue := escapeEnvValue(secrets.Entries[key])
 		str.WriteString(fmt.Sprintf("%s=\"%s\"\n", key, escapedValue))
 	}
 	fmt.Print(str.String())
@@ -67,10 +75,90 @@ func escapeEnvValue(value string) string {
 	return value
 }
 
+func ExportFish(secrets *secret.Secrets) {
+	slog.Debug("Exporting secrets as fish commands")
+	writeFish(os.Stdout, secrets)
+}
+
+func writeFish(w io.Writer, secrets *secret.Secrets) {
+	var str strings.Builder
+
+	for _, key := range safeKeys(sortedKeys(secrets)) {
+		escapedValue := escapeFishValue(secrets.Entries[key])
+		str.WriteString(fmt.Sprintf("set -gx %s '%s'\n", key, escapedValue))
+	}
+	fmt.Fprint(w, str.String())
+}
+
+// escapeFishValue escapes a value for a single-quoted fish string, where
+// only backslash and single quote are special
+func escapeFishValue(value string) string {
+	value = strings.ReplaceAll(value, "\\", "\\\\")
+	return strings.ReplaceAll(value, "'", "\\'")
+}
+
+// Write writes the export commands for the shell formats "shell" (bash and
+// zsh) and "fish" to w.
+func Write(w io.Writer, secrets *secret.Secrets, format string) error {
+	switch format {
+	case "shell":
+		writeShell(w, secrets)
+	case "fish":
+		writeFish(w, secrets)
+	default:
+		return fmt.Errorf("unknown shell format %q", format)
+	}
+	return nil
+}
+
+// Unset writes the commands that remove the variables named by keys in the
+// shell format (see Write) to w.
+func Unset(w io.Writer, keys []string, format string) error {
+	var command string
+	switch format {
+	case "shell":
+		command = "unset %s\n"
+	case "fish":
+		command = "set -e %s\n"
+	default:
+		return fmt.Errorf("unknown shell format %q", format)
+	}
+	for _, key := range safeKeys(keys) {
+		fmt.Fprintf(w, command, key)
+	}
+	return nil
+}
+
+// safeKeys returns the keys that are valid variable names, which are the
+// only ones that can be written unquoted into shell commands. The pipeline
+// never produces others; anything that slips through is dropped here.
+func safeKeys(keys []string) []string {
+	safe := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if !transform.ValidName(key) {
+			slog.Warn("Skipping secret whose name is not a valid environment variable name", "name", key)
+			continue
+		}
+		safe = append(safe, key)
+	}
+	return safe
+}
+
+func sortedKeys(secrets *secret.Secrets) []string {
+	keys := make([]string, 0, len(secrets.Entries))
+	for key := range secrets.Entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func Export(secrets *secret.Secrets, format string) {
 	switch format {
 	case "shell":
 		ExportShell(secrets)
+	case "fish":
+		ExportFish(secrets)
 	case "json":
 		ExportJSON(secrets)
 	case "env":
@@ -1,6 +1,8 @@
 package output
 
 import (
+	"bytes"
+	"strings"
 	"testing"
 
 	"github.com/napisani/secret_inject/internal/secret"
@@ -234,3 +236,57 @@ func TestExportEnv_Integration(t *testing.T) {
 		_ = escaped
 	}
 }
+
+func TestWriteFish(t *testing.T) {
+	secrets := secret.New()
+	secrets.Entries["B"] = `it's a \ secret`
+	secrets.Entries["A"] = "$PATH"
+
+	var buf bytes.Buffer
+	if err := Write(&buf, secrets, "fish"); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	want := "set -gx A '$PATH'\nset -gx B 'it\\'s a \\\\ secret'\n"
+	if buf.String() != want {
+		t.Errorf("Write(fish) = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestUnset(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Unset(&buf, []string{"A", "B"}, "shell"); err != nil {
+		t.Fatalf("Unset failed: %v", err)
+	}
+	if buf.String() != "unset A\nunset B\n" {
+		t.Errorf("Unset(shell) = %q", buf.String())
+	}
+
+	if err := Unset(&buf, []string{"A"}, "json"); err == nil {
+		t.Errorf("expected an error for a non-shell format")
+	}
+}
+
+func TestShellFormatsSkipInvalidNames(t *testing.T) {
+	secrets := secret.New()
+	secrets.Entries["x;echo INJECTED;y"] = "value"
+	secrets.Entries["GOOD"] = "value"
+
+	for _, format := range []string{"shell", "fish"} {
+		var buf bytes.Buffer
+		if err := Write(&buf, secrets, format); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(buf.String(), "INJECTED") || !strings.Contains(buf.String(), "GOOD") {
+			t.Errorf("expected only the valid name in %s output, got %q", format, buf.String())
+		}
+
+		buf.Reset()
+		if err := Unset(&buf, []string{"GOOD", "x;echo INJECTED;y"}, format); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(buf.String(), "INJECTED") {
+			t.Errorf("expected the invalid name to be skipped by %s unset, got %q", format, buf.String())
+		}
+	}
+}
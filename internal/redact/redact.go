@@ -0,0 +1,113 @@
+// Package redact masks known secret values in text the tool writes, such as
+// log records and error messages.
+package redact
+
+import (
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Mask replaces every registered value.
+const Mask = "[REDACTED]"
+
+// MinLength is the shortest value that is registered. Shorter values such as
+// "1" or "dev" would mask unrelated text and give away more than they hide.
+const MinLength = 4
+
+var (
+	mu       sync.Mutex
+	values   = make(map[string]bool)
+	replacer *strings.Replacer
+)
+
+// Register adds values to the set that is masked. Each line of a multi-line
+// value is registered as well, so a CLI echoing part of a certificate or
+// key is still masked.
+func Register(secrets ...string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, secret := range secrets {
+		add(secret)
+		if strings.Contains(secret, "\n") {
+			for _, line := range strings.Split(secret, "\n") {
+				add(line)
+			}
+		}
+	}
+}
+
+func add(value string) {
+	value = strings.TrimSpace(value)
+	if len(value) < MinLength || values[value] {
+		return
+	}
+	values[value] = true
+	replacer = nil
+}
+
+// Reset forgets every registered value.
+func Reset() {
+	mu.Lock()
+	defer mu.Unlock()
+	values = make(map[string]bool)
+	replacer = nil
+}
+
+// String returns s with every registered value replaced by Mask.
+func String(s string) string {
+	mu.Lock()
+	r := currentReplacer()
+	mu.Unlock()
+
+	if r == nil {
+		return s
+	}
+	return r.Replace(s)
+}
+
+// currentReplacer builds the replacer on first use after a change. Longer
+// values come first so a value containing another is masked as a whole.
+func currentReplacer() *strings.Replacer {
+	if replacer != nil || len(values) == 0 {
+		return replacer
+	}
+
+	sorted := make([]string, 0, len(values))
+	for value := range values {
+		sorted = append(sorted, value)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if len(sorted[i]) != len(sorted[j]) {
+			return len(sorted[i]) > len(sorted[j])
+		}
+		return sorted[i] < sorted[j]
+	})
+
+	pairs := make([]string, 0, 2*len(sorted))
+	for _, value := range sorted {
+		pairs = append(pairs, value, Mask)
+	}
+	replacer = strings.NewReplacer(pairs...)
+	return replacer
+}
+
+type writer struct {
+	w io.Writer
+}
+
+// Writer returns an io.Writer that masks registered values before writing
+// to w. Values split across two writes are not masked, so it is meant for
+// writers that receive whole lines, such as the log output.
+func Writer(w io.Writer) io.Writer {
+	return &writer{w: w}
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.w, String(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
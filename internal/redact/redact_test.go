@@ -0,0 +1,66 @@
+package redact
+
+import (
+	"bytes"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestStringMasksRegisteredValues(t *testing.T) {
+	Reset()
+	defer Reset()
+
+	Register("s3cr3t-token", "abc", "s3cr3t")
+
+	got := String("op read failed: s3cr3t-token and s3cr3t, abc")
+	want := "op read failed: [REDACTED] and [REDACTED], abc"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestRegisterMasksLinesOfMultilineValues(t *testing.T) {
+	Reset()
+	defer Reset()
+
+	Register("-----BEGIN KEY-----\nMIIEvQIBADANBgkq\n-----END KEY-----")
+
+	got := String("unexpected line MIIEvQIBADANBgkq")
+	if strings.Contains(got, "MIIEvQIBADANBgkq") {
+		t.Errorf("expected key line to be masked, got %q", got)
+	}
+}
+
+func TestStringWithoutRegisteredValues(t *testing.T) {
+	Reset()
+
+	if got := String("nothing to hide"); got != "nothing to hide" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestWriterMasksLogOutput(t *testing.T) {
+	Reset()
+	defer Reset()
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(Writer(&buf), nil))
+	Register("hunter22")
+
+	logger.Error("Error resolving secrets", "error", "doppler: bad value hunter22")
+
+	if strings.Contains(buf.String(), "hunter22") {
+		t.Fatalf("secret leaked into log output: %s", buf.String())
+	}
+	if !strings.Contains(buf.String(), Mask) {
+		t.Fatalf("expected mask in log output: %s", buf.String())
+	}
+
+	buf.Reset()
+	log.New(Writer(&buf), "", 0).Print("hunter22")
+	if got := strings.TrimSpace(buf.String()); got != Mask {
+		t.Fatalf("got %q, want %q", got, Mask)
+	}
+}
@@ -0,0 +1,116 @@
+package schema
+
+import (
+	"encoding/json"
+)
+
+// durationPattern matches the non-negative durations time.ParseDuration
+// accepts.
+const durationPattern = `^([0-9]*\.?[0-9]+(ns|us|µs|ms|s|m|h))+$`
+
+// JSONSchema renders s as a JSON Schema (draft 2020-12) document with every
+// definition it refers to under "$defs".
+func JSONSchema(s *Schema, id, title string) ([]byte, error) {
+	defs := make(map[string]interface{})
+	document := s.render(defs)
+	document["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	if id != "" {
+		document["$id"] = id
+	}
+	document["title"] = title
+	if len(defs) > 0 {
+		document["$defs"] = defs
+	}
+
+	encoded, err := json.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(encoded, '\n'), nil
+}
+
+func (s *Schema) render(defs map[string]interface{}) map[string]interface{} {
+	node := make(map[string]interface{})
+	if s.description != "" {
+		node["description"] = s.description
+	}
+	if s.target != nil {
+		if _, ok := defs[s.ref]; !ok {
+			// Reserve the name first so a definition that refers back to
+			// itself is only rendered once.
+			defs[s.ref] = nil
+			defs[s.ref] = s.target.render(defs)
+		}
+		node["$ref"] = "#/$defs/" + s.ref
+		return node
+	}
+
+	switch s.kind {
+	case kindObject:
+		node["type"] = "object"
+		properties := make(map[string]interface{}, len(s.properties))
+		var required []string
+		for _, prop := range s.properties {
+			properties[prop.name] = prop.schema.render(defs)
+			if prop.required {
+				required = append(required, prop.name)
+			}
+		}
+		node["properties"] = properties
+		if len(required) > 0 {
+			node["required"] = required
+		}
+		node["additionalProperties"] = false
+	case kindMap:
+		node["type"] = "object"
+		node["additionalProperties"] = s.elem.render(defs)
+		if s.min != nil {
+			node["minProperties"] = *s.min
+		}
+	case kindArray:
+		node["type"] = "array"
+		node["items"] = s.elem.render(defs)
+		if s.min != nil {
+			node["minItems"] = *s.min
+		}
+	case kindString:
+		node["type"] = "string"
+		if s.nonempty {
+			node["minLength"] = 1
+		}
+		if len(s.enum) > 0 {
+			node["enum"] = s.enum
+		}
+		switch {
+		case s.pattern != nil:
+			node["pattern"] = s.pattern.String()
+		case s.duration:
+			node["pattern"] = durationPattern
+		}
+	case kindInteger, kindNumber:
+		node["type"] = "number"
+		if s.kind == kindInteger {
+			node["type"] = "integer"
+		}
+		if s.min != nil {
+			node["minimum"] = *s.min
+		}
+	case kindBoolean:
+		node["type"] = "boolean"
+	case kindStringOr:
+		node["oneOf"] = []interface{}{
+			map[string]interface{}{"type": "string", "minLength": 1},
+			s.elem.render(defs),
+		}
+	}
+
+	if s.nullable {
+		delete(node, "description")
+		wrapped := map[string]interface{}{"anyOf": []interface{}{node, map[string]interface{}{"type": "null"}}}
+		if s.description != "" {
+			wrapped["description"] = s.description
+		}
+		return wrapped
+	}
+	return node
+}
@@ -0,0 +1,569 @@
+// Package schema describes the config file with Go types. The same
+// description validates a decoded document, collecting every problem with
+// its JSON path, and renders the JSON Schema published for editors.
+//
+// A schema is built from a struct with Of. Fields are named by their json
+// tag, described by a doc tag and constrained by a schema tag holding a
+// comma-separated list of:
+//
+//	required     the field must be set (a null value counts as unset)
+//	nonempty     strings must not be blank
+//	enum=a|b     strings must be one of the listed values
+//	pattern=re   strings must match the regular expression, unless their type
+//	             has a Validate method to explain what is wrong
+//	duration     strings must be a non-negative duration such as "30s"
+//	positive     durations must be greater than zero
+//	min=N        numbers must be at least N, maps and lists hold at least N entries
+//	ref=name     the field is described by the named definition
+//
+// String constraints on a map or list apply to its entries. A type with a
+// Validate method on its value is checked with it once its structure is
+// valid.
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type kind int
+
+const (
+	kindAny kind = iota
+	kindObject
+	kindMap
+	kindArray
+	kindString
+	kindInteger
+	kindNumber
+	kindBoolean
+	kindStringOr
+)
+
+// Validator is implemented by config types with rules the schema cannot
+// express, such as settings that depend on each other.
+type Validator interface {
+	Validate() error
+}
+
+// Schema describes a value of the config file.
+type Schema struct {
+	kind        kind
+	description string
+	// properties are the fields of an object in declaration order and noun
+	// names them in errors about unknown keys.
+	properties []property
+	noun       string
+	// elem describes map values, list items and the object form of StringOr.
+	elem *Schema
+
+	nonempty bool
+	enum     []string
+	pattern  *regexp.Regexp
+	duration bool
+	positive bool
+	min      *int
+	nullable bool
+
+	// ref names the definition this schema stands for.
+	ref    string
+	target *Schema
+	// goType is decoded and checked when it implements Validator.
+	goType reflect.Type
+}
+
+type property struct {
+	name     string
+	required bool
+	schema   *Schema
+}
+
+// Definitions are named schemas that fields refer to with a ref tag. They
+// are rendered once under "$defs" in the JSON Schema.
+type Definitions map[string]*Schema
+
+var validatorType = reflect.TypeOf((*Validator)(nil)).Elem()
+
+// Of builds the schema of v's type. It panics on types it cannot describe
+// or references to missing definitions, as both are programming errors.
+func Of(v interface{}, defs Definitions) *Schema {
+	return build(reflect.TypeOf(v), defs)
+}
+
+// Ref returns a schema standing for the definition name of target.
+func Ref(name string, target *Schema) *Schema {
+	return &Schema{ref: name, target: target}
+}
+
+// Set describes an object whose keys are the names of members, all of them
+// optional. Unknown keys are reported as an unknown noun.
+func Set(noun, description string, members map[string]*Schema) *Schema {
+	names := make([]string, 0, len(members))
+	for name := range members {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	s := &Schema{kind: kindObject, description: description, noun: noun}
+	for _, name := range names {
+		s.properties = append(s.properties, property{name: name, schema: members[name]})
+	}
+	return s
+}
+
+// Partial returns a copy of s that accepts null and in which no field is
+// required and no Validate method runs, for overrides that are merged into
+// a complete value before that is validated.
+func (s *Schema) Partial() *Schema {
+	for s.target != nil {
+		s = s.target
+	}
+	partial := *s
+	partial.properties = make([]property, len(s.properties))
+	for i, prop := range s.properties {
+		prop.required = false
+		partial.properties[i] = prop
+	}
+	partial.nullable = true
+	partial.goType = nil
+	return &partial
+}
+
+func build(t reflect.Type, defs Definitions) *Schema {
+	if t.Kind() == reflect.Pointer {
+		return build(t.Elem(), defs)
+	}
+
+	s := &Schema{}
+	// Only value receivers count, so a document type can have a pointer
+	// Validate method that validates against its own schema.
+	if t.Implements(validatorType) {
+		s.goType = t
+	}
+	if alt, ok := reflect.New(t).Interface().(alternative); ok {
+		s.kind = kindStringOr
+		s.elem = build(reflect.TypeOf(alt.object()), defs)
+		return s
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		s.kind = kindObject
+		s.noun = "field"
+		s.properties = structProperties(t, defs)
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			panic(fmt.Sprintf("schema: map keys of %s must be strings", t))
+		}
+		s.kind = kindMap
+		s.elem = build(t.Elem(), defs)
+	case reflect.Slice, reflect.Array:
+		s.kind = kindArray
+		s.elem = build(t.Elem(), defs)
+	case reflect.String:
+		s.kind = kindString
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		s.kind = kindInteger
+	case reflect.Float32, reflect.Float64:
+		s.kind = kindNumber
+	case reflect.Bool:
+		s.kind = kindBoolean
+	case reflect.Interface:
+		s.kind = kindAny
+	default:
+		panic(fmt.Sprintf("schema: cannot describe %s", t))
+	}
+	return s
+}
+
+// structProperties lists the fields of t the way encoding/json sees them,
+// with the fields of embedded structs promoted.
+func structProperties(t reflect.Type, defs Definitions) []property {
+	var properties []property
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() && !field.Anonymous {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if field.Anonymous && name == "" {
+			properties = append(properties, structProperties(field.Type, defs)...)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		prop := property{name: name}
+		var constraints []string
+		for _, option := range strings.Split(field.Tag.Get("schema"), ",") {
+			switch {
+			case option == "":
+			case option == "required":
+				prop.required = true
+			case strings.HasPrefix(option, "ref="):
+				target, ok := defs[strings.TrimPrefix(option, "ref=")]
+				if !ok {
+					panic(fmt.Sprintf("schema: %s.%s refers to unknown definition %q", t, field.Name, option))
+				}
+				prop.schema = Ref(strings.TrimPrefix(option, "ref="), target)
+			default:
+				constraints = append(constraints, option)
+			}
+		}
+		if prop.schema == nil {
+			prop.schema = build(field.Type, defs)
+		}
+		prop.schema.constrain(constraints)
+		prop.schema.description = field.Tag.Get("doc")
+		properties = append(properties, prop)
+	}
+	return properties
+}
+
+// constrain applies schema tag options, passing string constraints on to
+// the entries of maps and lists.
+func (s *Schema) constrain(options []string) {
+	for _, option := range options {
+		key, value, _ := strings.Cut(option, "=")
+		if key == "min" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				panic(fmt.Sprintf("schema: invalid option %q", option))
+			}
+			s.min = &n
+			continue
+		}
+
+		target := s
+		for (target.kind == kindMap || target.kind == kindArray) && target.elem != nil {
+			target = target.elem
+		}
+		switch key {
+		case "nonempty":
+			target.nonempty = true
+		case "enum":
+			target.enum = strings.Split(value, "|")
+		case "pattern":
+			target.pattern = regexp.MustCompile(value)
+		case "duration":
+			target.duration = true
+		case "positive":
+			target.positive = true
+		default:
+			panic(fmt.Sprintf("schema: unknown option %q", option))
+		}
+	}
+}
+
+// alternative is implemented by StringOr.
+type alternative interface {
+	object() interface{}
+}
+
+// StringOr holds a setting written either as a plain string or as an object
+// decoded into T.
+type StringOr[T any] struct {
+	String string
+	Object T
+}
+
+func (StringOr[T]) object() interface{} {
+	var zero T
+	return zero
+}
+
+// UnmarshalJSON decodes either form.
+func (v *StringOr[T]) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &v.String); err == nil {
+		return nil
+	}
+	return json.Unmarshal(data, &v.Object)
+}
+
+// Error is a problem with the value at Path, such as "$.sources.doppler.env".
+type Error struct {
+	Path    string
+	Message string
+}
+
+func (e Error) Error() string {
+	return e.Path + ": " + e.Message
+}
+
+// Errors lists every problem found in a document.
+type Errors []Error
+
+func (e Errors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	lines := make([]string, 0, len(e)+1)
+	lines = append(lines, fmt.Sprintf("%d problems:", len(e)))
+	for _, err := range e {
+		lines = append(lines, "  "+err.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Err returns e as an error, or nil if it is empty.
+func (e Errors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Validate checks a document decoded from JSON against the schema.
+func (s *Schema) Validate(document interface{}) Errors {
+	var errs Errors
+	s.validate("$", document, &errs)
+	return errs
+}
+
+func (s *Schema) validate(path string, value interface{}, errs *Errors) {
+	if s.target != nil {
+		s.target.validate(path, value, errs)
+		return
+	}
+	if value == nil && (s.nullable || s.kind == kindAny) {
+		return
+	}
+
+	before := len(*errs)
+	fail := func(format string, args ...interface{}) {
+		*errs = append(*errs, Error{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	switch s.kind {
+	case kindObject:
+		object, ok := value.(map[string]interface{})
+		if !ok && s.nullable {
+			fail("must be an object or null")
+			return
+		} else if !ok {
+			fail("must be an object")
+			return
+		}
+		s.validateObject(path, object, errs)
+	case kindMap:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			fail("must be an object")
+			return
+		}
+		for _, key := range sortedKeys(object) {
+			s.elem.validate(Join(path, key), object[key], errs)
+		}
+		if s.min != nil && len(object) < *s.min {
+			fail("must have at least %d %s", *s.min, plural(*s.min, "entry", "entries"))
+		}
+	case kindArray:
+		list, ok := value.([]interface{})
+		if !ok {
+			fail("must be a list")
+			return
+		}
+		for i, item := range list {
+			s.elem.validate(fmt.Sprintf("%s[%d]", path, i), item, errs)
+		}
+		if s.min != nil && len(list) < *s.min {
+			fail("must have at least %d %s", *s.min, plural(*s.min, "entry", "entries"))
+		}
+	case kindString:
+		str, ok := value.(string)
+		if !ok {
+			fail("must be a string")
+			return
+		}
+		if message := s.checkString(str); message != "" {
+			fail("%s", message)
+		}
+	case kindInteger, kindNumber:
+		number, ok := value.(float64)
+		if !ok {
+			fail("must be a number")
+			return
+		}
+		if s.kind == kindInteger && number != float64(int64(number)) {
+			fail("must be a whole number")
+			return
+		}
+		if s.min != nil && number < float64(*s.min) {
+			fail("must be at least %d", *s.min)
+		}
+	case kindBoolean:
+		if _, ok := value.(bool); !ok {
+			fail("must be true or false")
+		}
+	case kindStringOr:
+		switch v := value.(type) {
+		case string:
+			if strings.TrimSpace(v) == "" {
+				fail("must not be empty")
+			}
+		case map[string]interface{}:
+			s.elem.validate(path, v, errs)
+		default:
+			fail("must be a string or an object")
+		}
+	}
+
+	if len(*errs) == before && s.goType != nil {
+		if err := check(s.goType, value); err != nil {
+			fail("%s", err)
+		}
+	}
+}
+
+func (s *Schema) validateObject(path string, object map[string]interface{}, errs *Errors) {
+	known := make(map[string]bool, len(s.properties))
+	names := make([]string, 0, len(s.properties))
+	for _, prop := range s.properties {
+		known[prop.name] = true
+		names = append(names, prop.name)
+
+		value, ok := object[prop.name]
+		if !ok || value == nil {
+			if prop.required {
+				*errs = append(*errs, Error{Path: Join(path, prop.name), Message: "is required"})
+			}
+			continue
+		}
+		prop.schema.validate(Join(path, prop.name), value, errs)
+	}
+
+	for _, key := range sortedKeys(object) {
+		if known[key] {
+			continue
+		}
+		message := fmt.Sprintf("unknown %s %q", s.noun, key)
+		if suggestion := Suggest(key, names); suggestion != "" {
+			message += fmt.Sprintf(" (did you mean %q?)", suggestion)
+		}
+		*errs = append(*errs, Error{Path: Join(path, key), Message: message})
+	}
+}
+
+// checkString returns what is wrong with str, or "" if nothing is.
+func (s *Schema) checkString(str string) string {
+	if s.nonempty && strings.TrimSpace(str) == "" {
+		return "must not be empty"
+	}
+	if len(s.enum) > 0 && !contains(s.enum, str) {
+		quoted := make([]string, len(s.enum))
+		for i, value := range s.enum {
+			quoted[i] = strconv.Quote(value)
+		}
+		message := "must be one of " + strings.Join(quoted, ", ")
+		if suggestion := Suggest(str, s.enum); suggestion != "" {
+			message += fmt.Sprintf(" (did you mean %q?)", suggestion)
+		}
+		return message
+	}
+	if s.pattern != nil && s.goType == nil && !s.pattern.MatchString(str) {
+		return fmt.Sprintf("must match %s", s.pattern)
+	}
+	if s.duration {
+		duration, err := time.ParseDuration(str)
+		switch {
+		case err != nil:
+			return fmt.Sprintf("must be a duration such as \"30s\" or \"24h\", got %q", str)
+		case s.positive && duration <= 0:
+			return "must be positive"
+		case duration < 0:
+			return "must not be negative"
+		}
+	}
+	return ""
+}
+
+// check decodes value into a t and runs its Validate method.
+func check(t reflect.Type, value interface{}) error {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	decoded := reflect.New(t)
+	if err := json.Unmarshal(encoded, decoded.Interface()); err != nil {
+		return err
+	}
+	return decoded.Interface().(Validator).Validate()
+}
+
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Join appends key to a JSON path, quoting keys that are not identifiers.
+func Join(path, key string) string {
+	if identifier.MatchString(key) {
+		return path + "." + key
+	}
+	return path + "[" + strconv.Quote(key) + "]"
+}
+
+// Suggest returns the candidate closest to name if it is close enough to be
+// a likely typo, or "" if none is.
+func Suggest(name string, candidates []string) string {
+	best, bestDistance := "", 3
+	for _, candidate := range candidates {
+		if distance := levenshtein(strings.ToLower(name), strings.ToLower(candidate)); distance < bestDistance {
+			best, bestDistance = candidate, distance
+		}
+	}
+	return best
+}
+
+func levenshtein(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+func sortedKeys(object map[string]interface{}) []string {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
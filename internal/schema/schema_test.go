@@ -0,0 +1,161 @@
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testRetry struct {
+	Attempts int    `json:"attempts,omitempty" schema:"min=1"`
+	Backoff  string `json:"backoff,omitempty" schema:"duration,positive"`
+}
+
+type testCommon struct {
+	TTL   string     `json:"ttl,omitempty" schema:"duration,positive"`
+	Retry *testRetry `json:"retry,omitempty"`
+}
+
+type testEntry struct {
+	ID  string `json:"id,omitempty"`
+	Key string `json:"key,omitempty"`
+}
+
+func (e testEntry) Validate() error {
+	if e.ID == "" && e.Key == "" {
+		return errors.New("must set 'id' or 'key'")
+	}
+	return nil
+}
+
+type testSource struct {
+	testCommon
+	Project string                         `json:"project" schema:"required,nonempty" doc:"Project to read"`
+	Mode    string                         `json:"mode,omitempty" schema:"enum=token|approle"`
+	Paths   []string                       `json:"paths,omitempty" schema:"nonempty"`
+	Secrets map[string]StringOr[testEntry] `json:"secrets,omitempty" schema:"min=1"`
+}
+
+type testDocument struct {
+	Sources map[string]interface{} `json:"sources,omitempty" schema:"ref=sources"`
+	Workers int                    `json:"workers,omitempty" schema:"min=0"`
+}
+
+func testSchema() *Schema {
+	source := Of(testSource{}, nil)
+	defs := Definitions{
+		"sources": Set("source", "Sources", map[string]*Schema{"example": Ref("example", source)}),
+	}
+	return Of(testDocument{}, defs)
+}
+
+func decode(t *testing.T, document string) interface{} {
+	t.Helper()
+	var decoded interface{}
+	if err := json.Unmarshal([]byte(document), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
+
+func TestValidateCollectsEveryError(t *testing.T) {
+	document := decode(t, `{
+		"workers": -1,
+		"sources": {
+			"exampel": {},
+			"example": {
+				"projct": "app",
+				"mode": "tokn",
+				"ttl": "soon",
+				"retry": {"attempts": 0},
+				"paths": ["ok", " "],
+				"secrets": {"A": "id-a", "B": {"key": "b"}, "C": {}, "D": 5, "with space": ""}
+			}
+		}
+	}`)
+
+	var got []string
+	for _, err := range testSchema().Validate(document) {
+		got = append(got, err.Error())
+	}
+	want := []string{
+		`$.sources.example.ttl: must be a duration such as "30s" or "24h", got "soon"`,
+		`$.sources.example.retry.attempts: must be at least 1`,
+		`$.sources.example.project: is required`,
+		`$.sources.example.mode: must be one of "token", "approle" (did you mean "token"?)`,
+		`$.sources.example.paths[1]: must not be empty`,
+		`$.sources.example.secrets.C: must set 'id' or 'key'`,
+		`$.sources.example.secrets.D: must be a string or an object`,
+		`$.sources.example.secrets["with space"]: must not be empty`,
+		`$.sources.example.projct: unknown field "projct" (did you mean "project"?)`,
+		`$.sources.exampel: unknown source "exampel" (did you mean "example"?)`,
+		`$.workers: must be at least 0`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected errors:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestValidateAcceptsValidDocument(t *testing.T) {
+	document := decode(t, `{"sources": {"example": {"project": "app", "ttl": "1h", "secrets": {"A": "id"}}}}`)
+	if errs := testSchema().Validate(document); errs.Err() != nil {
+		t.Errorf("expected no errors, got %v", errs)
+	}
+}
+
+func TestPartialAllowsNullAndMissingFields(t *testing.T) {
+	partial := Of(testSource{}, nil).Partial()
+	if errs := partial.Validate(nil); errs.Err() != nil {
+		t.Errorf("expected null to be accepted, got %v", errs)
+	}
+	if errs := partial.Validate(decode(t, `{"mode": "approle"}`)); errs.Err() != nil {
+		t.Errorf("expected missing required fields to be accepted, got %v", errs)
+	}
+	if errs := partial.Validate(decode(t, `{"mode": "other"}`)); len(errs) != 1 {
+		t.Errorf("expected field constraints to still apply, got %v", errs)
+	}
+}
+
+func TestJSONSchema(t *testing.T) {
+	encoded, err := JSONSchema(testSchema(), "", "Test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var document map[string]interface{}
+	if err := json.Unmarshal(encoded, &document); err != nil {
+		t.Fatal(err)
+	}
+
+	defs := document["$defs"].(map[string]interface{})
+	example := defs["example"].(map[string]interface{})
+	if !reflect.DeepEqual(example["required"], []interface{}{"project"}) || example["additionalProperties"] != false {
+		t.Errorf("unexpected source definition: %v", example)
+	}
+	properties := example["properties"].(map[string]interface{})
+	if project := properties["project"].(map[string]interface{}); project["description"] != "Project to read" || project["minLength"] != 1.0 {
+		t.Errorf("unexpected project property: %v", project)
+	}
+	if _, ok := properties["ttl"]; !ok {
+		t.Errorf("expected embedded fields to be promoted, got %v", properties)
+	}
+	sources := document["properties"].(map[string]interface{})["sources"].(map[string]interface{})
+	if sources["$ref"] != "#/$defs/sources" {
+		t.Errorf("expected sources to refer to its definition, got %v", sources)
+	}
+}
+
+func TestErrorsFormatting(t *testing.T) {
+	one := Errors{{Path: "$.a", Message: "is required"}}
+	if one.Error() != "$.a: is required" {
+		t.Errorf("unexpected single error %q", one.Error())
+	}
+	two := append(one, Error{Path: "$.b", Message: "must be a string"})
+	if two.Error() != "2 problems:\n  $.a: is required\n  $.b: must be a string" {
+		t.Errorf("unexpected errors %q", two.Error())
+	}
+	if (Errors{}).Err() != nil {
+		t.Errorf("expected no error for an empty list")
+	}
+}
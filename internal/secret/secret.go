@@ -1,20 +1,49 @@
 package secret
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"log/slog"
+	"sort"
 	"time"
 )
 
 type Secrets struct {
 	Entries   map[string]string `json:"entries"`
 	Timestamp time.Time         `json:"timestamp"`
+	// Fingerprint identifies the source configuration the entries were
+	// fetched with, so a cache written under a different config is ignored.
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// Metadata records the provenance of each entry. Caches written before
+	// it existed have none, so every accessor tolerates missing metadata.
+	Metadata map[string]Metadata `json:"metadata,omitempty"`
+	// Fetches records when each source was last fetched and which keys it
+	// supplied, so sources can be refreshed independently of each other.
+	Fetches map[string]Fetch `json:"fetches,omitempty"`
+}
+
+// Metadata describes where a secret came from.
+type Metadata struct {
+	Source    string    `json:"source,omitempty"`
+	Reference string    `json:"reference,omitempty"`
+	FetchedAt time.Time `json:"fetched_at"`
+	Hash      string    `json:"hash,omitempty"`
+}
+
+// Fetch describes the last time a source was fetched.
+type Fetch struct {
+	FetchedAt time.Time `json:"fetched_at"`
+	Keys      []string  `json:"keys,omitempty"`
 }
 
 func New() *Secrets {
 	return &Secrets{
 		Entries:   make(map[string]string),
 		Timestamp: time.Now(),
+		Metadata:  make(map[string]Metadata),
+		Fetches:   make(map[string]Fetch),
 	}
 }
 
@@ -33,21 +62,145 @@ func Deserialize(jsonBytes []byte) (*Secrets, error) {
 	if err != nil {
 		return nil, err
 	}
+	if secrets.Entries == nil {
+		secrets.Entries = make(map[string]string)
+	}
+	if secrets.Metadata == nil {
+		secrets.Metadata = make(map[string]Metadata)
+	}
+	if secrets.Fetches == nil {
+		secrets.Fetches = make(map[string]Fetch)
+	}
 	slog.Debug("Deserialized secrets", "count", len(secrets.Entries))
 	return &secrets, nil
 }
 
+// Set stores a secret along with the reference it was resolved from (an
+// op:// URI, a Bitwarden id, a Doppler project/config, ...).
+func (s *Secrets) Set(key string, value string, reference string) {
+	s.Entries[key] = value
+	if s.Metadata == nil {
+		s.Metadata = make(map[string]Metadata)
+	}
+	s.Metadata[key] = Metadata{Reference: reference}
+}
+
+// Meta returns the provenance of key, if it is known.
+func (s *Secrets) Meta(key string) (Metadata, bool) {
+	meta, ok := s.Metadata[key]
+	return meta, ok
+}
+
+// Stamp records the source name, fetch time and value hash of every entry,
+// keeping any reference already set.
+func (s *Secrets) Stamp(source string, fetchedAt time.Time) {
+	if s.Metadata == nil {
+		s.Metadata = make(map[string]Metadata, len(s.Entries))
+	}
+	for key, value := range s.Entries {
+		meta := s.Metadata[key]
+		meta.Source = source
+		meta.FetchedAt = fetchedAt
+		meta.Hash = HashValue(value)
+		s.Metadata[key] = meta
+	}
+}
+
+// HashValue returns the hex-encoded SHA-256 of a secret value, which lets
+// changes be detected without storing or comparing the value itself.
+func HashValue(value string) string {
+	hash := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(hash[:])
+}
+
 func (s *Secrets) Append(other *Secrets) *Secrets {
 	result := New()
 	for key, value := range s.Entries {
 		result.Entries[key] = value
+		if meta, ok := s.Metadata[key]; ok {
+			result.Metadata[key] = meta
+		}
 	}
 	for key, value := range other.Entries {
 		result.Entries[key] = value
+		if meta, ok := other.Metadata[key]; ok {
+			result.Metadata[key] = meta
+		} else {
+			delete(result.Metadata, key)
+		}
 	}
 	return result
 }
 
+// Filter returns a copy of the secrets holding only the entries for which
+// keep returns true.
+func (s *Secrets) Filter(keep func(key string) bool) *Secrets {
+	result := &Secrets{
+		Entries:     make(map[string]string, len(s.Entries)),
+		Timestamp:   s.Timestamp,
+		Fingerprint: s.Fingerprint,
+		Metadata:    make(map[string]Metadata, len(s.Metadata)),
+		Fetches:     s.Fetches,
+	}
+	for key, value := range s.Entries {
+		if keep(key) {
+			result.Entries[key] = value
+			if meta, ok := s.Metadata[key]; ok {
+				result.Metadata[key] = meta
+			}
+		}
+	}
+	return result
+}
+
+// RenameKeys returns a copy of the secrets with every key replaced by the
+// result of rename. It fails if rename fails or maps two keys to one name.
+func (s *Secrets) RenameKeys(rename func(key string) (string, error)) (*Secrets, error) {
+	keys := make([]string, 0, len(s.Entries))
+	for key := range s.Entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := &Secrets{
+		Entries:     make(map[string]string, len(s.Entries)),
+		Timestamp:   s.Timestamp,
+		Fingerprint: s.Fingerprint,
+		Metadata:    make(map[string]Metadata, len(s.Metadata)),
+		Fetches:     s.Fetches,
+	}
+	origins := make(map[string]string, len(s.Entries))
+	for _, key := range keys {
+		renamed, err := rename(key)
+		if err != nil {
+			return nil, err
+		}
+		if origin, exists := origins[renamed]; exists {
+			return nil, fmt.Errorf("both %s and %s map to %s", origin, key, renamed)
+		}
+		origins[renamed] = key
+		result.Entries[renamed] = s.Entries[key]
+		if meta, ok := s.Metadata[key]; ok {
+			result.Metadata[renamed] = meta
+		}
+	}
+	return result, nil
+}
+
+// OldestFetch returns when the least recently fetched source was fetched,
+// or the cache timestamp if no fetch times were recorded.
+func (s *Secrets) OldestFetch() time.Time {
+	oldest := s.Timestamp
+	first := true
+	for _, fetch := range s.Fetches {
+		if first || fetch.FetchedAt.Before(oldest) {
+			oldest = fetch.FetchedAt
+			first = false
+		}
+	}
+	return oldest
+}
+
 func (s *Secrets) IsExpired(ttl time.Duration) bool {
 	return time.Since(s.Timestamp) > ttl
 }
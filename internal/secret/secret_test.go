@@ -115,3 +115,86 @@ func TestIsNotExpired(t *testing.T) {
 		t.Error("Expected secrets to not be expired")
 	}
 }
+
+func TestMetadataRoundTrip(t *testing.T) {
+	fetchedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	s := New()
+	s.Set("API_KEY", "value", "op://vault/item/password")
+	s.Stamp("onepassword", fetchedAt)
+
+	data, err := s.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize failed: %v", err)
+	}
+
+	s2, err := Deserialize(data)
+	if err != nil {
+		t.Fatalf("Deserialize failed: %v", err)
+	}
+
+	meta, ok := s2.Meta("API_KEY")
+	if !ok {
+		t.Fatal("expected metadata for API_KEY")
+	}
+	if meta.Source != "onepassword" || meta.Reference != "op://vault/item/password" {
+		t.Errorf("unexpected metadata: %+v", meta)
+	}
+	if !meta.FetchedAt.Equal(fetchedAt) {
+		t.Errorf("FetchedAt: got %v want %v", meta.FetchedAt, fetchedAt)
+	}
+	if meta.Hash != HashValue("value") {
+		t.Errorf("Hash: got %q want %q", meta.Hash, HashValue("value"))
+	}
+}
+
+func TestDeserializeWithoutMetadata(t *testing.T) {
+	s, err := Deserialize([]byte(`{"entries":{"KEY":"value"},"timestamp":"2024-01-02T03:04:05Z"}`))
+	if err != nil {
+		t.Fatalf("Deserialize failed: %v", err)
+	}
+
+	if s.Entries["KEY"] != "value" {
+		t.Errorf("Expected value, got %s", s.Entries["KEY"])
+	}
+	if _, ok := s.Meta("KEY"); ok {
+		t.Error("expected no metadata for legacy cache")
+	}
+
+	// Legacy caches must still accept new metadata.
+	s.Stamp("doppler", time.Now())
+	if meta, _ := s.Meta("KEY"); meta.Source != "doppler" {
+		t.Errorf("expected source doppler, got %q", meta.Source)
+	}
+}
+
+func TestMetadataFollowsEntries(t *testing.T) {
+	s1 := New()
+	s1.Set("KEY1", "value1", "ref1")
+	s1.Stamp("first", time.Now())
+
+	s2 := New()
+	s2.Set("KEY1", "override", "ref2")
+	s2.Set("KEY2", "value2", "ref3")
+	s2.Stamp("second", time.Now())
+
+	appended := s1.Append(s2)
+	if meta, _ := appended.Meta("KEY1"); meta.Source != "second" || meta.Reference != "ref2" {
+		t.Errorf("expected KEY1 metadata from second, got %+v", meta)
+	}
+
+	filtered := appended.Filter(func(key string) bool { return key == "KEY2" })
+	if _, ok := filtered.Meta("KEY1"); ok {
+		t.Error("expected KEY1 metadata to be filtered out")
+	}
+	if meta, _ := filtered.Meta("KEY2"); meta.Reference != "ref3" {
+		t.Errorf("expected KEY2 metadata to survive filtering, got %+v", meta)
+	}
+
+	renamed, err := filtered.RenameKeys(func(key string) (string, error) { return "APP_" + key, nil })
+	if err != nil {
+		t.Fatalf("RenameKeys failed: %v", err)
+	}
+	if meta, _ := renamed.Meta("APP_KEY2"); meta.Reference != "ref3" || meta.Source != "second" {
+		t.Errorf("expected metadata to follow renamed key, got %+v", meta)
+	}
+}
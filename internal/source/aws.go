@@ -0,0 +1,339 @@
+package source
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/napisani/secret_inject/internal/secret"
+)
+
+// AWSConfig is the "aws" source block.
+type AWSConfig struct {
+	CommonConfig
+	Secrets        map[string]awsSecretReference `json:"secrets,omitempty" schema:"nonempty" doc:"Environment variable names mapped to Secrets Manager secret ids, optionally with an 'id#field' suffix selecting a key of a JSON secret."`
+	SecretJSON     []string                      `json:"secret_json,omitempty" schema:"nonempty" doc:"Secrets Manager JSON secrets whose keys are all loaded."`
+	Parameters     map[string]string             `json:"parameters,omitempty" schema:"nonempty" doc:"Environment variable names mapped to SSM parameter names."`
+	ParameterPaths []string                      `json:"parameter_paths,omitempty" schema:"nonempty" doc:"SSM parameter paths whose parameters are all loaded."`
+	Profile        string                        `json:"profile,omitempty" doc:"AWS CLI profile to use."`
+	Region         string                        `json:"region,omitempty" doc:"AWS region to use."`
+}
+
+// Validate checks that the block reads at least one secret.
+func (c AWSConfig) Validate() error {
+	if len(c.Secrets)+len(c.SecretJSON)+len(c.Parameters)+len(c.ParameterPaths) == 0 {
+		return errors.New("must include at least one of 'secrets', 'secret_json', 'parameters' or 'parameter_paths'")
+	}
+	return nil
+}
+
+// awsSecretReference is a Secrets Manager secret id with an optional
+// '#field' suffix.
+type awsSecretReference string
+
+// Validate checks the form of the reference.
+func (r awsSecretReference) Validate() error {
+	if id, field, ok := r.split(); ok && (id == "" || field == "") {
+		return errors.New("must have the form 'id#field'")
+	}
+	return nil
+}
+
+// split returns the secret id and, if the reference selects one, the key of
+// the JSON secret.
+func (r awsSecretReference) split() (id string, field string, ok bool) {
+	ref := strings.TrimSpace(string(r))
+	if idx := strings.LastIndex(ref, "#"); idx >= 0 {
+		return ref[:idx], ref[idx+1:], true
+	}
+	return ref, "", false
+}
+
+type awsSecretConfig struct {
+	envVar string
+	id     string
+	field  string
+}
+
+type AWS struct {
+	secrets        []awsSecretConfig
+	secretJSON     []string
+	parameters     []awsSecretConfig
+	parameterPaths []string
+	profile        string
+	region         string
+	cli            cliRunner
+	concurrency    int
+	enabled        bool
+}
+
+type awsSecretValue struct {
+	SecretString *string `json:"SecretString"`
+}
+
+type awsParameter struct {
+	Name  string `json:"Name"`
+	Value string `json:"Value"`
+}
+
+type awsGetParameterResponse struct {
+	Parameter awsParameter `json:"Parameter"`
+}
+
+type awsGetParametersByPathResponse struct {
+	Parameters []awsParameter `json:"Parameters"`
+}
+
+func init() {
+	registerSource("aws", func() Source { return NewAWS() })
+	registerConfig("aws", AWSConfig{})
+	registerDiagnostics("aws", cliDiagnostics{
+		binary:      "aws",
+		versionArgs: []string{"--version"},
+		authCommand: func(fullConfig map[string]interface{}) ([]string, []string) {
+			var config AWSConfig
+			decodeConfig(fullConfig, "aws", &config)
+			args := []string{"sts", "get-caller-identity"}
+			if profile := strings.TrimSpace(config.Profile); profile != "" {
+				args = append(args, "--profile", profile)
+			}
+			if region := strings.TrimSpace(config.Region); region != "" {
+				args = append(args, "--region", region)
+			}
+			return args, nil
+		},
+		installHint: "install the AWS CLI: https://docs.aws.amazon.com/cli/latest/userguide/getting-started-install.html",
+		authHint:    "run 'aws configure' or 'aws sso login', or set AWS credentials in the environment",
+	})
+}
+
+func NewAWS() *AWS {
+	return &AWS{}
+}
+
+func (s *AWS) Init(fullConfig map[string]interface{}) error {
+	s.enabled = false
+	var config AWSConfig
+	if ok, err := decodeConfig(fullConfig, "aws", &config); !ok || err != nil {
+		return err
+	}
+
+	if _, err := lookupBinary("aws"); err != nil {
+		return fmt.Errorf("aws CLI not found: %w", err)
+	}
+
+	secrets := make([]awsSecretConfig, 0, len(config.Secrets))
+	for envVar, ref := range config.Secrets {
+		id, field, _ := ref.split()
+		secrets = append(secrets, awsSecretConfig{envVar: envVar, id: id, field: field})
+	}
+	sort.Slice(secrets, func(i, j int) bool { return secrets[i].envVar < secrets[j].envVar })
+
+	parameters := make([]awsSecretConfig, 0, len(config.Parameters))
+	for envVar, name := range config.Parameters {
+		parameters = append(parameters, awsSecretConfig{envVar: envVar, id: strings.TrimSpace(name)})
+	}
+	sort.Slice(parameters, func(i, j int) bool { return parameters[i].envVar < parameters[j].envVar })
+
+	s.secrets = secrets
+	s.secretJSON = trimAll(config.SecretJSON)
+	s.parameters = parameters
+	s.parameterPaths = trimAll(config.ParameterPaths)
+	s.profile = strings.TrimSpace(config.Profile)
+	s.region = strings.TrimSpace(config.Region)
+	s.cli = newCLIRunner(config.CommonConfig)
+	s.concurrency = concurrencyLimit(fullConfig)
+	s.enabled = true
+	return nil
+}
+
+func (s *AWS) GetAllSecrets(previous *secret.Secrets) (*secret.Secrets, error) {
+	env := buildCommandEnv(previous)
+
+	// Fetch every distinct secret once, whether individual keys are selected
+	// from it or it is exploded wholesale.
+	seen := make(map[string]bool)
+	var secretIDs []string
+	for _, entry := range s.secrets {
+		if !seen[entry.id] {
+			seen[entry.id] = true
+			secretIDs = append(secretIDs, entry.id)
+		}
+	}
+	for _, id := range s.secretJSON {
+		if !seen[id] {
+			seen[id] = true
+			secretIDs = append(secretIDs, id)
+		}
+	}
+
+	secretValues := make([]string, len(secretIDs))
+	parameterValues := make([]string, len(s.parameters))
+	pathValues := make([]map[string]awsParameter, len(s.parameterPaths))
+
+	tasks := make([]func() error, 0, len(secretIDs)+len(s.parameters)+len(s.parameterPaths))
+	for i, id := range secretIDs {
+		tasks = append(tasks, func() error {
+			value, err := s.getSecretValue(env, id)
+			secretValues[i] = value
+			return err
+		})
+	}
+	for i, entry := range s.parameters {
+		tasks = append(tasks, func() error {
+			value, err := s.getParameter(env, entry.id)
+			parameterValues[i] = value
+			return err
+		})
+	}
+	for i, path := range s.parameterPaths {
+		tasks = append(tasks, func() error {
+			values, err := s.getParametersByPath(env, path)
+			pathValues[i] = values
+			return err
+		})
+	}
+
+	err := forEachLimit(len(tasks), s.concurrency, func(i int) error {
+		return tasks[i]()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	secretsByID := make(map[string]string, len(secretIDs))
+	for i, id := range secretIDs {
+		secretsByID[id] = secretValues[i]
+	}
+
+	results := secret.New()
+	for _, values := range pathValues {
+		for key, param := range values {
+			results.Set(key, param.Value, param.Name)
+		}
+	}
+	for _, id := range s.secretJSON {
+		fields, err := parseAWSJSONSecret(id, secretsByID[id])
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range fields {
+			results.Set(key, value, id+"#"+key)
+		}
+	}
+	for i, entry := range s.parameters {
+		results.Set(entry.envVar, parameterValues[i], entry.id)
+	}
+	for _, entry := range s.secrets {
+		value := secretsByID[entry.id]
+		reference := entry.id
+		if entry.field != "" {
+			reference += "#" + entry.field
+			fields, err := parseAWSJSONSecret(entry.id, value)
+			if err != nil {
+				return nil, err
+			}
+			var ok bool
+			value, ok = fields[entry.field]
+			if !ok {
+				return nil, fmt.Errorf("aws secret %s has no key %s", entry.id, entry.field)
+			}
+		}
+		results.Set(entry.envVar, value, reference)
+	}
+
+	return results, nil
+}
+
+// commonArgs returns the profile and region flags shared by every call.
+func (s *AWS) commonArgs() []string {
+	var args []string
+	if s.profile != "" {
+		args = append(args, "--profile", s.profile)
+	}
+	if s.region != "" {
+		args = append(args, "--region", s.region)
+	}
+	return append(args, "--output", "json")
+}
+
+func (s *AWS) getSecretValue(env []string, id string) (string, error) {
+	args := append([]string{"secretsmanager", "get-secret-value", "--secret-id", id}, s.commonArgs()...)
+	output, err := s.cli.run("aws", env, args...)
+	if err != nil {
+		return "", err
+	}
+
+	var payload awsSecretValue
+	if err := json.Unmarshal(output, &payload); err != nil {
+		return "", fmt.Errorf("failed to parse aws secret %s: %w", id, err)
+	}
+	if payload.SecretString == nil {
+		return "", fmt.Errorf("aws secret %s has no string value (binary secrets are not supported)", id)
+	}
+	return strings.TrimSpace(*payload.SecretString), nil
+}
+
+func (s *AWS) getParameter(env []string, name string) (string, error) {
+	args := append([]string{"ssm", "get-parameter", "--name", name, "--with-decryption"}, s.commonArgs()...)
+	output, err := s.cli.run("aws", env, args...)
+	if err != nil {
+		return "", err
+	}
+
+	var payload awsGetParameterResponse
+	if err := json.Unmarshal(output, &payload); err != nil {
+		return "", fmt.Errorf("failed to parse aws parameter %s: %w", name, err)
+	}
+	return strings.TrimSpace(payload.Parameter.Value), nil
+}
+
+// getParametersByPath returns every parameter under path, keyed by its name
+// relative to the path with '/' separators replaced by '_'.
+func (s *AWS) getParametersByPath(env []string, path string) (map[string]awsParameter, error) {
+	args := append([]string{"ssm", "get-parameters-by-path", "--path", path, "--recursive", "--with-decryption"}, s.commonArgs()...)
+	output, err := s.cli.run("aws", env, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var payload awsGetParametersByPathResponse
+	if err := json.Unmarshal(output, &payload); err != nil {
+		return nil, fmt.Errorf("failed to parse aws parameters under %s: %w", path, err)
+	}
+
+	prefix := strings.TrimSuffix(path, "/") + "/"
+	values := make(map[string]awsParameter, len(payload.Parameters))
+	for _, param := range payload.Parameters {
+		name := strings.TrimPrefix(param.Name, prefix)
+		name = strings.ReplaceAll(strings.Trim(name, "/"), "/", "_")
+		if name == "" {
+			continue
+		}
+		values[name] = awsParameter{Name: param.Name, Value: strings.TrimSpace(param.Value)}
+	}
+	return values, nil
+}
+
+func parseAWSJSONSecret(id string, value string) (map[string]string, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal([]byte(value), &raw); err != nil {
+		return nil, fmt.Errorf("aws secret %s is not a JSON object: %w", id, err)
+	}
+
+	fields := make(map[string]string, len(raw))
+	for key, value := range raw {
+		formatted, err := formatJSONValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode key %s of aws secret %s: %w", key, id, err)
+		}
+		fields[key] = formatted
+	}
+	return fields, nil
+}
+
+func (s *AWS) IsEnabled() bool {
+	return s.enabled
+}
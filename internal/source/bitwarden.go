@@ -2,12 +2,37 @@ package source
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
 	"strings"
 
+	"github.com/napisani/secret_inject/internal/schema"
 	"github.com/napisani/secret_inject/internal/secret"
 )
 
+// BitwardenConfig is the "bitwarden" source block.
+type BitwardenConfig struct {
+	CommonConfig
+	Secrets map[string]schema.StringOr[BitwardenSecret] `json:"secrets" schema:"required,min=1" doc:"Environment variable names mapped to a secret id or to an object selecting the secret by id or by key."`
+}
+
+// BitwardenSecret selects a secret by id, or by key within an optional
+// project.
+type BitwardenSecret struct {
+	ID        string `json:"id,omitempty" doc:"Id of the secret."`
+	Key       string `json:"key,omitempty" doc:"Key of the secret, used when no id is given."`
+	ProjectID string `json:"project_id,omitempty" doc:"Project to look the key up in."`
+}
+
+// Validate checks that the secret can be found.
+func (s BitwardenSecret) Validate() error {
+	if strings.TrimSpace(s.ID) == "" && strings.TrimSpace(s.Key) == "" {
+		return errors.New("must include 'id' or non-empty 'key'")
+	}
+	return nil
+}
+
 type bitwardenSecretConfig struct {
 	envVar    string
 	id        string
@@ -16,9 +41,11 @@ type bitwardenSecretConfig struct {
 }
 
 type Bitwarden struct {
-	byID    []bitwardenSecretConfig
-	byKey   map[string][]bitwardenSecretConfig
-	enabled bool
+	byID        []bitwardenSecretConfig
+	byKey       map[string][]bitwardenSecretConfig
+	cli         cliRunner
+	concurrency int
+	enabled     bool
 }
 
 type bitwardenSecret struct {
@@ -29,6 +56,16 @@ type bitwardenSecret struct {
 
 func init() {
 	registerSource("bitwarden", func() Source { return NewBitwarden() })
+	registerConfig("bitwarden", BitwardenConfig{})
+	registerDiagnostics("bitwarden", cliDiagnostics{
+		binary:      "bws",
+		versionArgs: []string{"--version"},
+		authCommand: func(map[string]interface{}) ([]string, []string) {
+			return []string{"project", "list"}, nil
+		},
+		installHint: "install the Bitwarden Secrets Manager CLI: https://bitwarden.com/help/secrets-manager-cli/",
+		authHint:    "set BWS_ACCESS_TOKEN to a machine account access token",
+	})
 }
 
 func NewBitwarden() *Bitwarden {
@@ -38,65 +75,38 @@ func NewBitwarden() *Bitwarden {
 }
 
 func (s *Bitwarden) Init(fullConfig map[string]interface{}) error {
-	sources, ok := fullConfig["sources"].(map[string]interface{})
-	if !ok {
-		s.enabled = false
-		return nil
+	s.enabled = false
+	var config BitwardenConfig
+	if ok, err := decodeConfig(fullConfig, "bitwarden", &config); !ok || err != nil {
+		return err
 	}
 
-	rawConfig, ok := sources["bitwarden"].(map[string]interface{})
-	if !ok {
-		s.enabled = false
-		return nil
-	}
-
-	rawSecrets, ok := rawConfig["secrets"].(map[string]interface{})
-	if !ok || len(rawSecrets) == 0 {
-		s.enabled = false
-		return fmt.Errorf("bitwarden source requires a non-empty 'secrets' map")
+	if _, err := lookupBinary("bws"); err != nil {
+		return fmt.Errorf("bitwarden CLI 'bws' not found: %w", err)
 	}
 
 	var idEntries []bitwardenSecretConfig
 	keyEntries := make(map[string][]bitwardenSecretConfig)
-
-	for envVar, value := range rawSecrets {
-		switch v := value.(type) {
-		case string:
-			trimmed := strings.TrimSpace(v)
-			if trimmed == "" {
-				s.enabled = false
-				return fmt.Errorf("bitwarden secret id for %s cannot be empty", envVar)
-			}
-			idEntries = append(idEntries, bitwardenSecretConfig{envVar: envVar, id: trimmed})
-		case map[string]interface{}:
-			if idVal, ok := v["id"].(string); ok && strings.TrimSpace(idVal) != "" {
-				idEntries = append(idEntries, bitwardenSecretConfig{envVar: envVar, id: strings.TrimSpace(idVal)})
-				continue
-			}
-
-			keyVal, ok := v["key"].(string)
-			if !ok || strings.TrimSpace(keyVal) == "" {
-				s.enabled = false
-				return fmt.Errorf("bitwarden secret config for %s must include 'id' or non-empty 'key'", envVar)
-			}
-
-			projectID, _ := v["project_id"].(string)
-			projectID = strings.TrimSpace(projectID)
-			entry := bitwardenSecretConfig{envVar: envVar, key: strings.TrimSpace(keyVal), projectID: projectID}
-			keyEntries[projectID] = append(keyEntries[projectID], entry)
-		default:
-			s.enabled = false
-			return fmt.Errorf("bitwarden secret config for %s must be a string or object", envVar)
+	for envVar, value := range config.Secrets {
+		id := strings.TrimSpace(value.String)
+		if id == "" {
+			id = strings.TrimSpace(value.Object.ID)
+		}
+		if id != "" {
+			idEntries = append(idEntries, bitwardenSecretConfig{envVar: envVar, id: id})
+			continue
 		}
-	}
 
-	if _, err := lookupBinary("bws"); err != nil {
-		s.enabled = false
-		return fmt.Errorf("bitwarden CLI 'bws' not found: %w", err)
+		projectID := strings.TrimSpace(value.Object.ProjectID)
+		entry := bitwardenSecretConfig{envVar: envVar, key: strings.TrimSpace(value.Object.Key), projectID: projectID}
+		keyEntries[projectID] = append(keyEntries[projectID], entry)
 	}
+	sort.Slice(idEntries, func(i, j int) bool { return idEntries[i].envVar < idEntries[j].envVar })
 
 	s.byID = idEntries
 	s.byKey = keyEntries
+	s.cli = newCLIRunner(config.CommonConfig)
+	s.concurrency = concurrencyLimit(fullConfig)
 	s.enabled = true
 	return nil
 }
@@ -105,18 +115,27 @@ func (s *Bitwarden) GetAllSecrets(previous *secret.Secrets) (*secret.Secrets, er
 	results := secret.New()
 	env := buildCommandEnv(previous)
 
-	for _, entry := range s.byID {
-		output, err := runCLICommand("bws", env, "secret", "get", entry.id)
+	values := make([]string, len(s.byID))
+	err := forEachLimit(len(s.byID), s.concurrency, func(i int) error {
+		entry := s.byID[i]
+		output, err := s.cli.run("bws", env, "secret", "get", entry.id)
 		if err != nil {
-			return nil, err
+			return err
 		}
 
 		var payload bitwardenSecret
 		if err := json.Unmarshal(output, &payload); err != nil {
-			return nil, fmt.Errorf("failed to parse bitwarden secret %s: %w", entry.id, err)
+			return fmt.Errorf("failed to parse bitwarden secret %s: %w", entry.id, err)
 		}
 
-		results.Entries[entry.envVar] = strings.TrimSpace(payload.Value)
+		values[i] = strings.TrimSpace(payload.Value)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, entry := range s.byID {
+		results.Set(entry.envVar, values[i], entry.id)
 	}
 
 	for projectID, entries := range s.byKey {
@@ -125,7 +144,7 @@ func (s *Bitwarden) GetAllSecrets(previous *secret.Secrets) (*secret.Secrets, er
 			args = append(args, projectID)
 		}
 
-		output, err := runCLICommand("bws", env, args...)
+		output, err := s.cli.run("bws", env, args...)
 		if err != nil {
 			return nil, err
 		}
@@ -145,7 +164,11 @@ func (s *Bitwarden) GetAllSecrets(previous *secret.Secrets) (*secret.Secrets, er
 			if !ok {
 				return nil, fmt.Errorf("bitwarden secret with key %s not found", entry.key)
 			}
-			results.Entries[entry.envVar] = strings.TrimSpace(value)
+			reference := entry.key
+			if projectID != "" {
+				reference = projectID + "/" + entry.key
+			}
+			results.Set(entry.envVar, strings.TrimSpace(value), reference)
 		}
 	}
 
@@ -0,0 +1,113 @@
+package source
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+
+	"github.com/napisani/secret_inject/internal/secret"
+)
+
+// Policies for secrets provided by more than one source.
+const (
+	CollisionLastWins  = "last-wins"
+	CollisionFirstWins = "first-wins"
+	CollisionError     = "error"
+	CollisionWarn      = "warn"
+)
+
+// Collision records a secret provided by more than one source.
+type Collision struct {
+	Key    string
+	Winner string
+	Loser  string
+}
+
+// Report describes where every merged secret came from and which sources'
+// values were discarded along the way.
+type Report struct {
+	Origins    map[string]string
+	Collisions []Collision
+}
+
+// Overrode returns the sources whose value for key was discarded in favour
+// of the final one, in merge order.
+func (r *Report) Overrode(key string) []string {
+	var losers []string
+	for _, collision := range r.Collisions {
+		if collision.Key == key {
+			losers = append(losers, collision.Loser)
+		}
+	}
+	return losers
+}
+
+// Keys returns the merged secret names in sorted order.
+func (r *Report) Keys() []string {
+	keys := make([]string, 0, len(r.Origins))
+	for key := range r.Origins {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// keysFrom returns the merged secret names supplied by source in sorted
+// order.
+func (r *Report) keysFrom(source string) []string {
+	var keys []string
+	for _, key := range r.Keys() {
+		if r.Origins[key] == source {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func collisionPolicy(fullConfig map[string]interface{}) (string, error) {
+	policy, _ := fullConfig["collision_policy"].(string)
+	switch policy {
+	case "":
+		return CollisionLastWins, nil
+	case CollisionLastWins, CollisionFirstWins, CollisionError, CollisionWarn:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("collision_policy must be one of %s, %s, %s or %s",
+			CollisionLastWins, CollisionFirstWins, CollisionError, CollisionWarn)
+	}
+}
+
+// merge adds the secrets of the named source to merged according to policy
+// and records the outcome in report.
+func merge(merged *secret.Secrets, report *Report, name string, secrets *secret.Secrets, policy string) (*secret.Secrets, error) {
+	keys := make([]string, 0, len(secrets.Entries))
+	for key := range secrets.Entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	incoming := secrets
+	for _, key := range keys {
+		owner, exists := report.Origins[key]
+		if !exists {
+			report.Origins[key] = name
+			continue
+		}
+
+		switch policy {
+		case CollisionError:
+			return nil, fmt.Errorf("secret %s is provided by both %s and %s", key, owner, name)
+		case CollisionFirstWins:
+			report.Collisions = append(report.Collisions, Collision{Key: key, Winner: owner, Loser: name})
+			incoming = incoming.Filter(func(candidate string) bool { return candidate != key })
+		default:
+			if policy == CollisionWarn {
+				slog.Warn("Secret provided by multiple sources", "key", key, "used", name, "discarded", owner)
+			}
+			report.Collisions = append(report.Collisions, Collision{Key: key, Winner: name, Loser: owner})
+			report.Origins[key] = name
+		}
+	}
+
+	return merged.Append(incoming), nil
+}
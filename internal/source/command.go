@@ -1,7 +1,9 @@
 package source
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
@@ -9,6 +11,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/napisani/secret_inject/internal/redact"
 	"github.com/napisani/secret_inject/internal/secret"
 )
 
@@ -16,23 +19,113 @@ var (
 	commandTimeout = 30 * time.Second
 	runCLICommand  = defaultRunCLICommand
 	lookupBinary   = exec.LookPath
+	sleep          = time.Sleep
 )
 
-func defaultRunCLICommand(name string, env []string, args ...string) ([]byte, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
-	defer cancel()
+// CommandRunner runs the CLI name with args and env, feeding it stdin when
+// not empty, and returns its output.
+type CommandRunner func(ctx context.Context, name string, env []string, stdin string, args ...string) ([]byte, error)
 
+// StubCommands replaces how sources find and run their CLIs, so packages
+// built on sources can be tested without installing them. A nil argument
+// keeps the current behavior. The returned function restores the previous
+// one.
+func StubCommands(run CommandRunner, look func(string) (string, error)) (restore func()) {
+	originalRun := runCLICommand
+	originalLookup := lookupBinary
+	if run != nil {
+		runCLICommand = run
+	}
+	if look != nil {
+		lookupBinary = look
+	}
+	return func() {
+		runCLICommand = originalRun
+		lookupBinary = originalLookup
+	}
+}
+
+// credentialEnvVars are the variables the source CLIs read credentials
+// from. Their values are masked like secrets, whether they come from the
+// environment or from an earlier source.
+var credentialEnvVars = []string{
+	"DOPPLER_TOKEN",
+	"OP_SERVICE_ACCOUNT_TOKEN",
+	"OP_CONNECT_TOKEN",
+	"BWS_ACCESS_TOKEN",
+	"VAULT_TOKEN",
+	"VAULT_SECRET_ID",
+	"AWS_SECRET_ACCESS_KEY",
+	"AWS_SESSION_TOKEN",
+}
+
+// registerCredentials registers the credentials in env for redaction.
+func registerCredentials(env []string) {
+	for _, key := range credentialEnvVars {
+		if value := envValue(env, key); value != "" {
+			redact.Register(value)
+		}
+	}
+}
+
+// commandError describes a CLI invocation that failed, keeping its exit
+// code and error output so the failure can be classified as transient or
+// not. Standard output is never kept, since a command that fails part way
+// may already have printed secrets there.
+type commandError struct {
+	name     string
+	args     []string
+	exitCode int
+	output   string
+	timedOut bool
+	err      error
+}
+
+func (e *commandError) Error() string {
+	return redact.String(fmt.Sprintf("%s %s: %v\n%s", e.name, strings.Join(e.args, " "), e.err, e.output))
+}
+
+func (e *commandError) Unwrap() error {
+	return e.err
+}
+
+// defaultRunCLICommand runs the command with stdin, when not empty, as its
+// standard input. Values that must not show up in the process list or in
+// error messages are passed that way instead of as arguments.
+func defaultRunCLICommand(ctx context.Context, name string, env []string, stdin string, args ...string) ([]byte, error) {
 	cmd := exec.CommandContext(ctx, name, args...)
 	cmd.Env = env
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return nil, fmt.Errorf("%s %s: %w\n%s", name, strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	if stdin != "" {
+		cmd.Stdin = strings.NewReader(stdin)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		cmdErr := &commandError{
+			name:     name,
+			args:     args,
+			exitCode: -1,
+			output:   strings.TrimSpace(stderr.String()),
+			err:      err,
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			cmdErr.exitCode = exitErr.ExitCode()
+		}
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			cmdErr.timedOut = true
+			cmdErr.err = fmt.Errorf("timed out: %w", ctx.Err())
+		}
+		return nil, cmdErr
 	}
-	return output, nil
+	return stdout.Bytes(), nil
 }
 
 func buildCommandEnv(previous *secret.Secrets) []string {
+	// Secrets of earlier sources are registered when they are fetched.
 	env := os.Environ()
+	registerCredentials(env)
 	if previous == nil || len(previous.Entries) == 0 {
 		return env
 	}
@@ -48,3 +141,15 @@ func buildCommandEnv(previous *secret.Secrets) []string {
 	}
 	return env
 }
+
+// envValue returns the value of key in env. Later entries take precedence,
+// matching how exec resolves duplicates.
+func envValue(env []string, key string) string {
+	for i := len(env) - 1; i >= 0; i-- {
+		name, value, ok := strings.Cut(env[i], "=")
+		if ok && name == key {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,116 @@
+package source
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/napisani/secret_inject/internal/filter"
+	"github.com/napisani/secret_inject/internal/schema"
+	"github.com/napisani/secret_inject/internal/transform"
+)
+
+// CommonConfig holds the settings every source block accepts.
+type CommonConfig struct {
+	TTL       string            `json:"ttl,omitempty" schema:"duration,positive" doc:"How long this source's cached secrets stay fresh, overriding --ttl, such as \"10m\"."`
+	Timeout   string            `json:"timeout,omitempty" schema:"duration,positive" doc:"How long a single CLI command may run, such as \"30s\"."`
+	Retry     *RetryConfig      `json:"retry,omitempty" doc:"How transient CLI failures are retried."`
+	Filter    *filter.Config    `json:"filter,omitempty" doc:"Selects which of this source's secrets are kept."`
+	Transform *transform.Config `json:"transform,omitempty" doc:"Renames this source's secrets."`
+}
+
+// RetryConfig is the 'retry' setting of a source block (see newCLIRunner).
+type RetryConfig struct {
+	Attempts   int      `json:"attempts,omitempty" schema:"min=1" doc:"How many times a command is run at most."`
+	Backoff    string   `json:"backoff,omitempty" schema:"duration,positive" doc:"Delay before the first retry, doubled for each further one."`
+	MaxBackoff string   `json:"max_backoff,omitempty" schema:"duration,positive" doc:"Upper bound for the delay between retries."`
+	ExitCodes  []int    `json:"exit_codes,omitempty" doc:"Extra exit codes that mark a failure as transient."`
+	Patterns   []string `json:"patterns,omitempty" schema:"nonempty" doc:"Extra case-insensitive output substrings that mark a failure as transient."`
+}
+
+// Validate checks max_backoff against backoff, either of which may be a
+// default.
+func (c RetryConfig) Validate() error {
+	if duration(c.MaxBackoff, defaultMaxBackoff) < duration(c.Backoff, defaultRetryBackoff) {
+		return errors.New("'max_backoff' must not be less than 'backoff'")
+	}
+	return nil
+}
+
+// duration parses a duration setting the schema has checked, or returns
+// fallback when it is unset.
+func duration(value string, fallback time.Duration) time.Duration {
+	if value == "" {
+		return fallback
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return fallback
+	}
+	return parsed
+}
+
+var configRegistry = map[string]*schema.Schema{}
+
+// registerConfig records the type of a source's config block, from which
+// its schema is derived.
+func registerConfig(name string, config interface{}) {
+	configRegistry[name] = schema.Of(config, nil)
+}
+
+// decodeConfig checks the block of the named source against its schema and
+// decodes it into config, which may also be just the block's CommonConfig.
+// It reports false if the source has no block. The schema, including the
+// Validate methods of the config types, holds every rule a block must
+// follow, so the config is ready to use once it is decoded.
+func decodeConfig(fullConfig map[string]interface{}, name string, config interface{}) (bool, error) {
+	sources, _ := fullConfig["sources"].(map[string]interface{})
+	rawConfig, ok := sources[name]
+	if !ok {
+		return false, nil
+	}
+
+	// Blocks built in Go rather than decoded from JSON hold ints where the
+	// schema expects JSON numbers.
+	var document interface{}
+	if err := decode(rawConfig, &document); err != nil {
+		return true, err
+	}
+	if errs := configRegistry[name].Validate(document); len(errs) > 0 {
+		path := schema.Join("$.sources", name)
+		for i := range errs {
+			errs[i].Path = path + strings.TrimPrefix(errs[i].Path, "$")
+		}
+		return true, errs
+	}
+	return true, decode(document, config)
+}
+
+// decode converts a value decoded from JSON into v.
+func decode(value interface{}, v interface{}) error {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(encoded, v)
+}
+
+// trimAll returns values with surrounding whitespace removed.
+func trimAll(values []string) []string {
+	trimmed := make([]string, len(values))
+	for i, value := range values {
+		trimmed[i] = strings.TrimSpace(value)
+	}
+	return trimmed
+}
+
+// ConfigSchemas returns the schema of each source's config block by source
+// name.
+func ConfigSchemas() map[string]*schema.Schema {
+	schemas := make(map[string]*schema.Schema, len(configRegistry))
+	for name, s := range configRegistry {
+		schemas[name] = s
+	}
+	return schemas
+}
@@ -0,0 +1,186 @@
+package source
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// CheckResult is the outcome of a single diagnostic check.
+type CheckResult int
+
+const (
+	CheckPass CheckResult = iota
+	CheckWarn
+	CheckFail
+)
+
+func (r CheckResult) String() string {
+	switch r {
+	case CheckPass:
+		return "ok"
+	case CheckWarn:
+		return "warn"
+	default:
+		return "fail"
+	}
+}
+
+// Check is one line of the doctor checklist. Hint tells the user how to fix
+// a check that did not pass.
+type Check struct {
+	Name   string
+	Result CheckResult
+	Detail string
+	Hint   string
+}
+
+// cliDiagnostics describes how to check that a source's CLI is installed and
+// authenticated without fetching any secrets.
+type cliDiagnostics struct {
+	binary      string
+	versionArgs []string
+	// authCommand returns a command that only succeeds when the CLI is
+	// authenticated, plus any environment it needs. No args skips the check.
+	// It is only called once the source's config has been found valid.
+	authCommand func(fullConfig map[string]interface{}) (args []string, env []string)
+	installHint string
+	authHint    string
+}
+
+var diagnosticsRegistry = map[string]cliDiagnostics{}
+
+func registerDiagnostics(name string, diagnostics cliDiagnostics) {
+	if _, exists := diagnosticsRegistry[name]; exists {
+		panic(fmt.Sprintf("diagnostics for source %s already registered", name))
+	}
+	diagnosticsRegistry[name] = diagnostics
+}
+
+// Diagnose checks every configured source in fetch order: that its CLI is
+// installed and runs, that its config is valid and that the CLI is
+// authenticated. A source fetched after a sequenced one may get its
+// credentials from it, so its failed authentication check is only a warning.
+func Diagnose(fullConfig map[string]interface{}) []Check {
+	registerCredentials(os.Environ())
+	sourcesConfig, _ := fullConfig["sources"].(map[string]interface{})
+	if len(sourcesConfig) == 0 {
+		return nil
+	}
+
+	names, sequenced, err := resolveSourceOrder(fullConfig, sourcesConfig)
+	if err != nil {
+		return []Check{{
+			Name:   "source_sequence",
+			Result: CheckFail,
+			Detail: err.Error(),
+			Hint:   "list only configured sources in source_sequence",
+		}}
+	}
+
+	var checks []Check
+	for i, name := range names {
+		checks = append(checks, diagnoseSource(fullConfig, name, i > 0 && sequenced > 0)...)
+	}
+	return checks
+}
+
+func diagnoseSource(fullConfig map[string]interface{}, name string, chained bool) []Check {
+	factory, ok := sourceRegistry[name]
+	if !ok {
+		return []Check{{
+			Name:   name,
+			Result: CheckFail,
+			Detail: fmt.Sprintf("unknown source %q", name),
+			Hint:   "remove it from 'sources' or check its spelling",
+		}}
+	}
+	diagnostics, ok := diagnosticsRegistry[name]
+	if !ok {
+		return nil
+	}
+
+	checks := make([]Check, 0, 4)
+	path, err := lookupBinary(diagnostics.binary)
+	if err != nil {
+		return append(checks, Check{
+			Name:   name + ": " + diagnostics.binary + " CLI",
+			Result: CheckFail,
+			Detail: "not found in PATH",
+			Hint:   diagnostics.installHint,
+		})
+	}
+	checks = append(checks, Check{Name: name + ": " + diagnostics.binary + " CLI", Result: CheckPass, Detail: path})
+
+	var config CommonConfig
+	_, err = decodeConfig(fullConfig, name, &config)
+	if err == nil {
+		err = factory().Init(fullConfig)
+	}
+	if err != nil {
+		return append(checks, Check{
+			Name:   name + ": config",
+			Result: CheckFail,
+			Detail: err.Error(),
+			Hint:   fmt.Sprintf("fix the 'sources.%s' block of the config file", name),
+		})
+	}
+	checks = append(checks, Check{Name: name + ": config", Result: CheckPass})
+	runner := newCLIRunner(config)
+
+	version := Check{Name: name + ": version", Result: CheckPass}
+	if output, err := runner.probe(diagnostics.binary, os.Environ(), diagnostics.versionArgs...); err != nil {
+		version.Result = CheckFail
+		version.Detail = probeFailure(err)
+		version.Hint = "reinstall the CLI: " + diagnostics.installHint
+	} else {
+		version.Detail = firstLine(string(output))
+	}
+	checks = append(checks, version)
+
+	if diagnostics.authCommand == nil {
+		return checks
+	}
+	args, extraEnv := diagnostics.authCommand(fullConfig)
+	if len(args) == 0 {
+		return checks
+	}
+	auth := Check{Name: name + ": authenticated", Result: CheckPass}
+	if _, err := runner.probe(diagnostics.binary, append(os.Environ(), extraEnv...), args...); err != nil {
+		auth.Result = CheckFail
+		auth.Detail = probeFailure(err)
+		auth.Hint = diagnostics.authHint
+		if chained {
+			auth.Result = CheckWarn
+			auth.Hint += " (ignore this if the credentials come from an earlier source in source_sequence)"
+		}
+	}
+	return append(checks, auth)
+}
+
+// probe runs a diagnostic command once, with the source's timeout but
+// without retries.
+func (r cliRunner) probe(name string, env []string, args ...string) ([]byte, error) {
+	return r.runOnce(name, env, "", args...)
+}
+
+// probeFailure summarizes a failed diagnostic command in one line.
+func probeFailure(err error) string {
+	var cmdErr *commandError
+	if !errors.As(err, &cmdErr) {
+		return err.Error()
+	}
+	if cmdErr.timedOut {
+		return "timed out"
+	}
+	if line := firstLine(cmdErr.output); line != "" {
+		return fmt.Sprintf("exit status %d: %s", cmdErr.exitCode, line)
+	}
+	return cmdErr.err.Error()
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(s), "\n")
+	return strings.TrimSpace(line)
+}
@@ -8,18 +8,31 @@ import (
 	"github.com/napisani/secret_inject/internal/secret"
 )
 
+// DopplerConfig is the "doppler" source block.
 type DopplerConfig struct {
-	Project string `json:"project"`
-	Env     string `json:"env"`
+	CommonConfig
+	Project string `json:"project" schema:"required,nonempty" doc:"Doppler project to read secrets from."`
+	Env     string `json:"env" schema:"required,nonempty" doc:"Doppler config (environment) of the project, such as dev or prd."`
 }
 
 type Doppler struct {
 	config  *DopplerConfig
+	cli     cliRunner
 	enabled bool
 }
 
 func init() {
 	registerSource("doppler", func() Source { return NewDoppler() })
+	registerConfig("doppler", DopplerConfig{})
+	registerDiagnostics("doppler", cliDiagnostics{
+		binary:      "doppler",
+		versionArgs: []string{"--version"},
+		authCommand: func(map[string]interface{}) ([]string, []string) {
+			return []string{"me", "--json"}, nil
+		},
+		installHint: "install the Doppler CLI: https://docs.doppler.com/docs/install-cli",
+		authHint:    "run 'doppler login' or set DOPPLER_TOKEN",
+	})
 }
 
 func NewDoppler() *Doppler {
@@ -29,46 +42,25 @@ func NewDoppler() *Doppler {
 }
 
 func (s *Doppler) Init(fullConfig map[string]interface{}) error {
-	sources, ok := fullConfig["sources"].(map[string]interface{})
-	if !ok {
-		s.enabled = false
-		return nil
+	s.enabled = false
+	var config DopplerConfig
+	if ok, err := decodeConfig(fullConfig, "doppler", &config); !ok || err != nil {
+		return err
 	}
-	rawDopplerConfig, ok := sources["doppler"].(map[string]interface{})
-	if !ok {
-		s.enabled = false
-		return nil
-	}
-
-	s.enabled = true
-	dopplerConfig := DopplerConfig{}
-
-	project, ok := rawDopplerConfig["project"].(string)
-	if !ok || project == "" {
-		s.enabled = false
-		return fmt.Errorf("doppler source requires 'project' field")
-	}
-	dopplerConfig.Project = project
-
-	env, ok := rawDopplerConfig["env"].(string)
-	if !ok || env == "" {
-		s.enabled = false
-		return fmt.Errorf("doppler source requires 'env' field")
-	}
-	dopplerConfig.Env = env
 
 	if _, err := lookupBinary("doppler"); err != nil {
-		s.enabled = false
 		return fmt.Errorf("doppler CLI not found: %w", err)
 	}
 
-	s.config = &dopplerConfig
+	s.config = &config
+	s.cli = newCLIRunner(config.CommonConfig)
+	s.enabled = true
 	return nil
 }
 
 func (s *Doppler) GetAllSecrets(previous *secret.Secrets) (*secret.Secrets, error) {
 	env := buildCommandEnv(previous)
-	output, err := runCLICommand("doppler", env, "--project", s.config.Project, "--json", "secrets", "--config", s.config.Env)
+	output, err := s.cli.run("doppler", env, "--project", s.config.Project, "--json", "secrets", "--config", s.config.Env)
 	if err != nil {
 		return nil, err
 	}
@@ -78,6 +70,7 @@ func (s *Doppler) GetAllSecrets(previous *secret.Secrets) (*secret.Secrets, erro
 		return nil, err
 	}
 
+	reference := fmt.Sprintf("%s/%s", s.config.Project, s.config.Env)
 	secrets := secret.New()
 	for key, value := range dopplerOutput {
 		entry, ok := value.(map[string]interface{})
@@ -90,7 +83,7 @@ func (s *Doppler) GetAllSecrets(previous *secret.Secrets) (*secret.Secrets, erro
 			return nil, fmt.Errorf("doppler secret missing computed field for %s", key)
 		}
 
-		secrets.Entries[key] = strings.TrimSpace(computed)
+		secrets.Set(key, strings.TrimSpace(computed), reference)
 	}
 
 	return secrets, nil
@@ -2,18 +2,37 @@ package source
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/napisani/secret_inject/internal/secret"
 )
 
+// OnePasswordConfig is the "onepassword" source block.
+type OnePasswordConfig struct {
+	CommonConfig
+	Secrets map[string]string `json:"secrets" schema:"required,min=1,nonempty" doc:"Environment variable names mapped to op:// secret references."`
+}
+
 type OnePassword struct {
-	secrets map[string]string
-	enabled bool
+	secrets     map[string]string
+	cli         cliRunner
+	concurrency int
+	enabled     bool
 }
 
 func init() {
 	registerSource("onepassword", func() Source { return NewOnePassword() })
+	registerConfig("onepassword", OnePasswordConfig{})
+	registerDiagnostics("onepassword", cliDiagnostics{
+		binary:      "op",
+		versionArgs: []string{"--version"},
+		authCommand: func(map[string]interface{}) ([]string, []string) {
+			return []string{"whoami"}, nil
+		},
+		installHint: "install the 1Password CLI: https://developer.1password.com/docs/cli/get-started/",
+		authHint:    "run 'op signin' or set OP_SERVICE_ACCOUNT_TOKEN",
+	})
 }
 
 func NewOnePassword() *OnePassword {
@@ -21,53 +40,53 @@ func NewOnePassword() *OnePassword {
 }
 
 func (s *OnePassword) Init(fullConfig map[string]interface{}) error {
-	sources, ok := fullConfig["sources"].(map[string]interface{})
-	if !ok {
-		s.enabled = false
-		return nil
-	}
-
-	rawConfig, ok := sources["onepassword"].(map[string]interface{})
-	if !ok {
-		s.enabled = false
-		return nil
+	s.enabled = false
+	var config OnePasswordConfig
+	if ok, err := decodeConfig(fullConfig, "onepassword", &config); !ok || err != nil {
+		return err
 	}
 
-	rawSecrets, ok := rawConfig["secrets"].(map[string]interface{})
-	if !ok || len(rawSecrets) == 0 {
-		s.enabled = false
-		return fmt.Errorf("onepassword source requires a non-empty 'secrets' map")
+	if _, err := lookupBinary("op"); err != nil {
+		return fmt.Errorf("1password CLI 'op' not found: %w", err)
 	}
 
-	secrets := make(map[string]string, len(rawSecrets))
-	for envVar, value := range rawSecrets {
-		ref, ok := value.(string)
-		if !ok || strings.TrimSpace(ref) == "" {
-			s.enabled = false
-			return fmt.Errorf("onepassword secret reference for %s must be a non-empty string", envVar)
-		}
+	secrets := make(map[string]string, len(config.Secrets))
+	for envVar, ref := range config.Secrets {
 		secrets[envVar] = strings.TrimSpace(ref)
 	}
 
-	if _, err := lookupBinary("op"); err != nil {
-		s.enabled = false
-		return fmt.Errorf("1password CLI 'op' not found: %w", err)
-	}
-
 	s.secrets = secrets
+	s.cli = newCLIRunner(config.CommonConfig)
+	s.concurrency = concurrencyLimit(fullConfig)
 	s.enabled = true
 	return nil
 }
 
 func (s *OnePassword) GetAllSecrets(previous *secret.Secrets) (*secret.Secrets, error) {
-	results := secret.New()
 	env := buildCommandEnv(previous)
-	for envVar, ref := range s.secrets {
-		output, err := runCLICommand("op", env, "read", ref)
+
+	envVars := make([]string, 0, len(s.secrets))
+	for envVar := range s.secrets {
+		envVars = append(envVars, envVar)
+	}
+	sort.Strings(envVars)
+
+	values := make([]string, len(envVars))
+	err := forEachLimit(len(envVars), s.concurrency, func(i int) error {
+		output, err := s.cli.run("op", env, "read", s.secrets[envVars[i]])
 		if err != nil {
-			return nil, err
+			return err
 		}
-		results.Entries[envVar] = strings.TrimSpace(string(output))
+		values[i] = strings.TrimSpace(string(output))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results := secret.New()
+	for i, envVar := range envVars {
+		results.Set(envVar, values[i], s.secrets[envVar])
 	}
 	return results, nil
 }
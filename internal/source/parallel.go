@@ -0,0 +1,72 @@
+package source
+
+import (
+	"sync"
+)
+
+const defaultConcurrency = 4
+
+// concurrencyLimit reads the optional top-level "concurrency" setting, which
+// caps how many CLI invocations run at once at each level of the pipeline.
+func concurrencyLimit(fullConfig map[string]interface{}) int {
+	switch v := fullConfig["concurrency"].(type) {
+	case int:
+		if v > 0 {
+			return v
+		}
+	case float64:
+		if v >= 1 {
+			return int(v)
+		}
+	}
+	return defaultConcurrency
+}
+
+// forEachLimit calls fn for every index in [0, n) with at most limit calls in
+// flight. Once a call fails no further calls are started, and the error of
+// the lowest failing index is returned so the outcome does not depend on
+// completion order.
+func forEachLimit(n int, limit int, fn func(i int) error) error {
+	if limit < 1 {
+		limit = 1
+	}
+
+	errs := make([]error, n)
+	slots := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failed := false
+
+	for i := 0; i < n; i++ {
+		slots <- struct{}{}
+
+		mu.Lock()
+		stop := failed
+		mu.Unlock()
+		if stop {
+			<-slots
+			break
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			if err := fn(i); err != nil {
+				errs[i] = err
+				mu.Lock()
+				failed = true
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,281 @@
+package source
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/napisani/secret_inject/internal/filter"
+	"github.com/napisani/secret_inject/internal/redact"
+	"github.com/napisani/secret_inject/internal/secret"
+	"github.com/napisani/secret_inject/internal/transform"
+)
+
+// Pipeline fetches secrets from every enabled source. Each source's results
+// pass through its own filter, its own transform and then the global
+// transform before they are merged, so renamed keys collide (and are
+// validated) exactly as they will be exported. Sources listed in
+// source_sequence run one after another so each can consume the secrets of
+// the ones before it; all remaining sources are independent and run
+// concurrently once the sequence has completed. Without a source_sequence
+// every source runs in alphabetical order, one after another.
+type Pipeline struct {
+	stages          [][]namedSource
+	transform       *transform.Rules
+	collisionPolicy string
+	concurrency     int
+}
+
+func NewPipeline(fullConfig map[string]interface{}) (*Pipeline, error) {
+	named, sequenced, err := loadNamed(fullConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	sourcesConfig, _ := fullConfig["sources"].(map[string]interface{})
+	for i := range named {
+		rawConfig, _ := sourcesConfig[named[i].name].(map[string]interface{})
+		rules, err := filter.FromRaw(rawConfig["filter"])
+		if err != nil {
+			return nil, fmt.Errorf("source %s filter: %w", named[i].name, err)
+		}
+		named[i].filter = rules
+
+		transformRules, err := transform.FromRaw(rawConfig["transform"])
+		if err != nil {
+			return nil, fmt.Errorf("source %s transform: %w", named[i].name, err)
+		}
+		named[i].transform = transformRules
+
+		ttl, err := sourceTTL(fullConfig, named[i].name)
+		if err != nil {
+			return nil, fmt.Errorf("source %s: %w", named[i].name, err)
+		}
+		named[i].ttl = ttl
+	}
+
+	globalTransform, err := transform.FromRaw(fullConfig["transform"])
+	if err != nil {
+		return nil, fmt.Errorf("transform: %w", err)
+	}
+
+	policy, err := collisionPolicy(fullConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	var stages [][]namedSource
+	for _, entry := range named[:sequenced] {
+		stages = append(stages, []namedSource{entry})
+	}
+	if len(named) > sequenced {
+		stages = append(stages, named[sequenced:])
+	}
+
+	return &Pipeline{
+		stages:          stages,
+		transform:       globalTransform,
+		collisionPolicy: policy,
+		concurrency:     concurrencyLimit(fullConfig),
+	}, nil
+}
+
+// Len returns the number of enabled sources in the pipeline.
+func (p *Pipeline) Len() int {
+	count := 0
+	for _, stage := range p.stages {
+		count += len(stage)
+	}
+	return count
+}
+
+// sourceTTL returns the 'ttl' of the named source's block, or 0 if it has
+// none.
+func sourceTTL(fullConfig map[string]interface{}, name string) (time.Duration, error) {
+	var config CommonConfig
+	if _, err := decodeConfig(fullConfig, name, &config); err != nil {
+		return 0, err
+	}
+	return duration(config.TTL, 0), nil
+}
+
+// Fetch runs every stage in order and merges the results according to the
+// collision policy. Within a stage the results are merged in source order,
+// so the outcome is the same regardless of which source finishes first. The
+// returned report lists which source supplied each secret.
+func (p *Pipeline) Fetch() (*secret.Secrets, *Report, error) {
+	return p.Refresh(nil, 0)
+}
+
+// Stale returns the names of the sources whose entries in cached have to be
+// fetched again, either because they are older than the source's ttl (or
+// defaultTTL when it has none) or because the cache does not hold all of
+// them.
+func (p *Pipeline) Stale(cached *secret.Secrets, defaultTTL time.Duration) []string {
+	return p.StaleBeyond(cached, defaultTTL, 0)
+}
+
+// StaleBeyond is like Stale but only reports sources that expired more than
+// grace ago.
+func (p *Pipeline) StaleBeyond(cached *secret.Secrets, defaultTTL time.Duration, grace time.Duration) []string {
+	var stale []string
+	for _, stage := range p.stages {
+		for _, entry := range stage {
+			if _, ok := p.reusable(entry, cached, defaultTTL, grace); !ok {
+				stale = append(stale, entry.name)
+			}
+		}
+	}
+	return stale
+}
+
+// Refresh works like Fetch but reuses the entries of every source that is
+// still fresh in cached and only calls the stale sources' CLIs. A nil cached
+// fetches every source.
+func (p *Pipeline) Refresh(cached *secret.Secrets, defaultTTL time.Duration) (*secret.Secrets, *Report, error) {
+	return p.refresh(cached, defaultTTL, nil)
+}
+
+// RefreshSources works like Refresh but only fetches the named sources.
+// Every other source is taken from cached whatever its age, or left out if
+// the cache does not hold it; the fetch times recorded for it are kept, so
+// a later Refresh still fetches it once it has expired.
+func (p *Pipeline) RefreshSources(cached *secret.Secrets, defaultTTL time.Duration, names []string) (*secret.Secrets, *Report, error) {
+	only := make(map[string]bool, len(names))
+	for _, name := range names {
+		only[name] = true
+	}
+	return p.refresh(cached, defaultTTL, only)
+}
+
+func (p *Pipeline) refresh(cached *secret.Secrets, defaultTTL time.Duration, only map[string]bool) (*secret.Secrets, *Report, error) {
+	secrets := secret.New()
+	report := &Report{Origins: make(map[string]string)}
+	fetches := make(map[string]secret.Fetch)
+	for _, stage := range p.stages {
+		results := make([]*secret.Secrets, len(stage))
+		records := make([]secret.Fetch, len(stage))
+		previous := secrets
+		err := forEachLimit(len(stage), p.concurrency, func(i int) error {
+			if only != nil && !only[stage[i].name] {
+				if result, ok := cachedEntries(stage[i], cached); ok {
+					results[i] = result
+					records[i] = cached.Fetches[stage[i].name]
+				}
+				return nil
+			}
+
+			if result, ok := p.reusable(stage[i], cached, defaultTTL, 0); ok {
+				slog.Debug("Reusing cached secrets from source", "source", stage[i].name)
+				results[i] = result
+				records[i] = cached.Fetches[stage[i].name]
+				return nil
+			}
+
+			slog.Debug("Fetching secrets from source", "source", stage[i].name)
+			fetchedAt := time.Now()
+			result, err := stage[i].source.GetAllSecrets(previous)
+			if err == nil {
+				for _, value := range result.Entries {
+					redact.Register(value)
+				}
+				result.Stamp(stage[i].name, fetchedAt)
+				result, err = p.process(stage[i], result)
+			}
+			if err != nil {
+				return fmt.Errorf("source %s: %w", stage[i].name, err)
+			}
+			results[i] = result
+			records[i] = secret.Fetch{FetchedAt: fetchedAt}
+			return nil
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for i, result := range results {
+			if result == nil {
+				continue
+			}
+			fetches[stage[i].name] = records[i]
+			secrets, err = merge(secrets, report, stage[i].name, result, p.collisionPolicy)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	// Only the keys a source supplied to the merged result are recorded, so
+	// a source that lost a collision does not look incomplete next time.
+	for name, fetch := range fetches {
+		fetch.Keys = report.keysFrom(name)
+		fetches[name] = fetch
+	}
+	secrets.Fetches = fetches
+	return secrets, report, nil
+}
+
+// reusable returns the cached entries of a source if they expired no more
+// than grace ago.
+func (p *Pipeline) reusable(entry namedSource, cached *secret.Secrets, defaultTTL time.Duration, grace time.Duration) (*secret.Secrets, bool) {
+	if cached == nil {
+		return nil, false
+	}
+	fetch, ok := cached.Fetches[entry.name]
+	if !ok {
+		return nil, false
+	}
+
+	ttl := entry.ttl
+	if ttl == 0 {
+		ttl = defaultTTL
+	}
+	if time.Since(fetch.FetchedAt) > ttl+grace {
+		return nil, false
+	}
+	return cachedEntries(entry, cached)
+}
+
+// cachedEntries returns the entries cached holds for a source, regardless of
+// their age. The recorded keys are the ones the source won, so a source
+// missing any of them is never taken from an incomplete cache.
+func cachedEntries(entry namedSource, cached *secret.Secrets) (*secret.Secrets, bool) {
+	if cached == nil {
+		return nil, false
+	}
+	fetch, ok := cached.Fetches[entry.name]
+	if !ok {
+		return nil, false
+	}
+
+	keys := make(map[string]bool, len(fetch.Keys))
+	for _, key := range fetch.Keys {
+		if meta, ok := cached.Meta(key); !ok || meta.Source != entry.name {
+			return nil, false
+		}
+		keys[key] = true
+	}
+	return cached.Filter(func(key string) bool { return keys[key] }), true
+}
+
+// process applies the source's filter and transforms to its raw results and
+// enforces valid variable names.
+func (p *Pipeline) process(entry namedSource, secrets *secret.Secrets) (*secret.Secrets, error) {
+	secrets = entry.filter.Apply(secrets)
+
+	secrets, err := entry.transform.Apply(secrets)
+	if err != nil {
+		return nil, err
+	}
+
+	secrets, err = p.transform.Apply(secrets)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := entry.transform.InvalidNames()
+	if policy == "" {
+		policy = p.transform.InvalidNames()
+	}
+	return transform.EnforceValidNames(secrets, policy)
+}
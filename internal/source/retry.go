@@ -0,0 +1,162 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRetryAttempts = 3
+	defaultRetryBackoff  = 500 * time.Millisecond
+	defaultMaxBackoff    = 5 * time.Second
+)
+
+// defaultRetryableExitCodes holds exit codes that signal a temporary
+// failure (EX_TEMPFAIL from sysexits.h).
+var defaultRetryableExitCodes = []int{75}
+
+// defaultRetryablePatterns are matched case-insensitively against a failed
+// command's output to recognise rate limits and network hiccups.
+var defaultRetryablePatterns = []string{
+	"rate limit",
+	"too many requests",
+	"429",
+	"502 bad gateway",
+	"503 service unavailable",
+	"504 gateway timeout",
+	"service unavailable",
+	"temporarily unavailable",
+	"connection refused",
+	"connection reset",
+	"i/o timeout",
+	"tls handshake timeout",
+	"no such host",
+	"network is unreachable",
+}
+
+// retryPolicy decides whether and when a failed CLI command is run again.
+type retryPolicy struct {
+	attempts   int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	exitCodes  map[int]bool
+	patterns   []string
+}
+
+// cliRunner runs a source's CLI commands with its timeout and retry policy.
+type cliRunner struct {
+	timeout time.Duration
+	retry   retryPolicy
+}
+
+// newCLIRunner applies the 'timeout' and 'retry' settings of a source
+// block:
+//
+//	"timeout": "30s",
+//	"retry": {"attempts": 3, "backoff": "500ms", "max_backoff": "5s",
+//	          "exit_codes": [75], "patterns": ["quota exceeded"]}
+//
+// Exit codes and patterns are added to the built-in ones.
+func newCLIRunner(config CommonConfig) cliRunner {
+	runner := cliRunner{
+		timeout: duration(config.Timeout, commandTimeout),
+		retry: retryPolicy{
+			attempts:   defaultRetryAttempts,
+			backoff:    defaultRetryBackoff,
+			maxBackoff: defaultMaxBackoff,
+			exitCodes:  make(map[int]bool),
+			patterns:   append([]string(nil), defaultRetryablePatterns...),
+		},
+	}
+	for _, code := range defaultRetryableExitCodes {
+		runner.retry.exitCodes[code] = true
+	}
+
+	retry := config.Retry
+	if retry == nil {
+		return runner
+	}
+	if retry.Attempts > 0 {
+		runner.retry.attempts = retry.Attempts
+	}
+	runner.retry.backoff = duration(retry.Backoff, defaultRetryBackoff)
+	runner.retry.maxBackoff = duration(retry.MaxBackoff, defaultMaxBackoff)
+	for _, code := range retry.ExitCodes {
+		runner.retry.exitCodes[code] = true
+	}
+	for _, pattern := range retry.Patterns {
+		runner.retry.patterns = append(runner.retry.patterns, strings.ToLower(strings.TrimSpace(pattern)))
+	}
+	return runner
+}
+
+// run executes the command, retrying transient failures with exponential
+// backoff and jitter until the attempts are used up.
+func (r cliRunner) run(name string, env []string, args ...string) ([]byte, error) {
+	for attempt := 1; ; attempt++ {
+		output, err := r.runOnce(name, env, "", args...)
+		if err == nil {
+			return output, nil
+		}
+
+		if attempt >= r.retry.attempts || !r.retry.retryable(err) {
+			return nil, err
+		}
+
+		delay := r.retry.delay(attempt)
+		slog.Debug("Retrying CLI command after transient failure",
+			"command", name, "attempt", attempt, "delay", delay, "error", err)
+		sleep(delay)
+	}
+}
+
+// runOnce runs the command a single time with the source's timeout, feeding
+// it stdin. It is for commands that must not be repeated, such as a login
+// that may use up a limited-use credential before the real failure shows.
+func (r cliRunner) runOnce(name string, env []string, stdin string, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+	return runCLICommand(ctx, name, env, stdin, args...)
+}
+
+// retryable reports whether err looks transient: a timeout, a retryable exit
+// code or output matching one of the patterns. Only the command's output is
+// matched, never its arguments, so an ID that happens to contain "429" does
+// not make a permanent failure look like a rate limit.
+func (p retryPolicy) retryable(err error) bool {
+	var cmdErr *commandError
+	if !errors.As(err, &cmdErr) {
+		return false
+	}
+	if cmdErr.timedOut || p.exitCodes[cmdErr.exitCode] {
+		return true
+	}
+
+	message := strings.ToLower(cmdErr.output)
+	for _, pattern := range p.patterns {
+		if strings.Contains(message, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// delay returns the backoff before the given retry: the base backoff doubled
+// per attempt and capped at maxBackoff, with "equal jitter" so concurrent
+// callers do not retry in lockstep.
+func (p retryPolicy) delay(attempt int) time.Duration {
+	backoff := p.backoff
+	for i := 1; i < attempt && backoff < p.maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > p.maxBackoff {
+		backoff = p.maxBackoff
+	}
+
+	half := backoff / 2
+	return half + rand.N(half+1)
+}
@@ -1,11 +1,15 @@
 package source
 
 import (
+	"encoding/json"
 	"fmt"
 	"sort"
 	"strings"
+	"time"
 
+	"github.com/napisani/secret_inject/internal/filter"
 	"github.com/napisani/secret_inject/internal/secret"
+	"github.com/napisani/secret_inject/internal/transform"
 )
 
 type Source interface {
@@ -23,7 +27,12 @@ func registerSource(name string, factory func() Source) {
 	sourceRegistry[name] = factory
 }
 
-func resolveSourceOrder(fullConfig map[string]interface{}, sourcesConfig map[string]interface{}) ([]string, error) {
+// resolveSourceOrder returns the configured source names in fetch order
+// along with how many of them (a prefix of the result) run one after
+// another. Without a source_sequence every source is sequenced in
+// alphabetical order, so each still receives the secrets of the ones before
+// it.
+func resolveSourceOrder(fullConfig map[string]interface{}, sourcesConfig map[string]interface{}) ([]string, int, error) {
 	sequenceRaw, hasSequence := fullConfig["source_sequence"]
 	if !hasSequence {
 		keys := make([]string, 0, len(sourcesConfig))
@@ -31,7 +40,7 @@ func resolveSourceOrder(fullConfig map[string]interface{}, sourcesConfig map[str
 			keys = append(keys, key)
 		}
 		sort.Strings(keys)
-		return keys, nil
+		return keys, len(keys), nil
 	}
 
 	sequenceSlice, ok := sequenceRaw.([]interface{})
@@ -42,7 +51,7 @@ func resolveSourceOrder(fullConfig map[string]interface{}, sourcesConfig map[str
 				sequenceSlice = append(sequenceSlice, name)
 			}
 		} else {
-			return nil, fmt.Errorf("source_sequence must be an array of strings")
+			return nil, 0, fmt.Errorf("source_sequence must be an array of strings")
 		}
 	}
 
@@ -51,10 +60,10 @@ func resolveSourceOrder(fullConfig map[string]interface{}, sourcesConfig map[str
 	for _, entry := range sequenceSlice {
 		name, ok := entry.(string)
 		if !ok || strings.TrimSpace(name) == "" {
-			return nil, fmt.Errorf("source_sequence entries must be non-empty strings")
+			return nil, 0, fmt.Errorf("source_sequence entries must be non-empty strings")
 		}
 		if _, exists := sourcesConfig[name]; !exists {
-			return nil, fmt.Errorf("source_sequence references unknown source %q", name)
+			return nil, 0, fmt.Errorf("source_sequence references unknown source %q", name)
 		}
 		if !seen[name] {
 			ordered = append(ordered, name)
@@ -62,6 +71,8 @@ func resolveSourceOrder(fullConfig map[string]interface{}, sourcesConfig map[str
 		}
 	}
 
+	sequenced := len(ordered)
+
 	remaining := make([]string, 0)
 	for key := range sourcesConfig {
 		if !seen[key] {
@@ -71,36 +82,118 @@ func resolveSourceOrder(fullConfig map[string]interface{}, sourcesConfig map[str
 	sort.Strings(remaining)
 	ordered = append(ordered, remaining...)
 
-	return ordered, nil
+	return ordered, sequenced, nil
 }
 
-func LoadAll(fullConfig map[string]interface{}) ([]Source, error) {
+type namedSource struct {
+	name      string
+	source    Source
+	filter    *filter.Rules
+	transform *transform.Rules
+	// ttl overrides the default cache TTL for this source when non-zero.
+	ttl time.Duration
+}
+
+// loadNamed initializes every configured source in fetch order and returns
+// the enabled ones along with how many of them come from source_sequence.
+func loadNamed(fullConfig map[string]interface{}) ([]namedSource, int, error) {
 	sourcesConfigRaw, _ := fullConfig["sources"].(map[string]interface{})
 	if len(sourcesConfigRaw) == 0 {
-		return nil, nil
+		return nil, 0, nil
 	}
 
-	keys, err := resolveSourceOrder(fullConfig, sourcesConfigRaw)
+	keys, sequencedKeys, err := resolveSourceOrder(fullConfig, sourcesConfigRaw)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
-	loaded := make([]Source, 0, len(keys))
-	for _, key := range keys {
+	loaded := make([]namedSource, 0, len(keys))
+	sequenced := 0
+	for i, key := range keys {
 		factory, ok := sourceRegistry[key]
 		if !ok {
-			return nil, fmt.Errorf("unknown source %q", key)
+			return nil, 0, fmt.Errorf("unknown source %q", key)
 		}
 
 		instance := factory()
 		if err := instance.Init(fullConfig); err != nil {
-			return nil, fmt.Errorf("initializing source %s: %w", key, err)
+			return nil, 0, fmt.Errorf("initializing source %s: %w", key, err)
 		}
 
 		if instance.IsEnabled() {
-			loaded = append(loaded, instance)
+			loaded = append(loaded, namedSource{name: key, source: instance})
+			if i < sequencedKeys {
+				sequenced++
+			}
 		}
 	}
 
+	return loaded, sequenced, nil
+}
+
+// Status describes how a configured source initialized.
+type Status struct {
+	Name    string
+	Enabled bool
+	Err     error
+	// TTL is the source's own cache TTL, or 0 if it uses the default.
+	TTL time.Duration
+}
+
+// Statuses initializes every configured source on its own, in name order, so
+// one misconfigured source does not hide the state of the others.
+func Statuses(fullConfig map[string]interface{}) []Status {
+	sourcesConfig, _ := fullConfig["sources"].(map[string]interface{})
+	names := make([]string, 0, len(sourcesConfig))
+	for name := range sourcesConfig {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	statuses := make([]Status, 0, len(names))
+	for _, name := range names {
+		status := Status{Name: name}
+		if factory, ok := sourceRegistry[name]; !ok {
+			status.Err = fmt.Errorf("unknown source %q", name)
+		} else {
+			instance := factory()
+			status.Err = instance.Init(fullConfig)
+			status.Enabled = status.Err == nil && instance.IsEnabled()
+		}
+		if status.Err == nil {
+			status.TTL, status.Err = sourceTTL(fullConfig, name)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+func LoadAll(fullConfig map[string]interface{}) ([]Source, error) {
+	named, _, err := loadNamed(fullConfig)
+	if err != nil || len(named) == 0 {
+		return nil, err
+	}
+
+	loaded := make([]Source, 0, len(named))
+	for _, entry := range named {
+		loaded = append(loaded, entry.source)
+	}
 	return loaded, nil
 }
+
+// formatJSONValue converts a decoded JSON value into an environment variable
+// value. Strings are used as-is and anything else is re-encoded as JSON.
+func formatJSONValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return strings.TrimSpace(v), nil
+	case nil:
+		return "", nil
+	default:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return string(encoded), nil
+	}
+}
@@ -1,26 +1,28 @@
 package source
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/napisani/secret_inject/internal/redact"
 	"github.com/napisani/secret_inject/internal/secret"
 )
 
 func withPatchedGlobals(run func(string, []string, ...string) ([]byte, error), look func(string) (string, error)) func() {
-	originalRun := runCLICommand
-	originalLookup := lookupBinary
+	var runner CommandRunner
 	if run != nil {
-		runCLICommand = run
-	}
-	if look != nil {
-		lookupBinary = look
-	}
-	return func() {
-		runCLICommand = originalRun
-		lookupBinary = originalLookup
+		runner = func(_ context.Context, name string, env []string, _ string, args ...string) ([]byte, error) {
+			return run(name, env, args...)
+		}
 	}
+	return StubCommands(runner, look)
 }
 
 func hasEnvVar(env []string, key string, value string) bool {
@@ -327,3 +329,1403 @@ func TestSourcesDisableWhenLookupFails(t *testing.T) {
 		t.Fatalf("expected onepassword to be disabled when no config")
 	}
 }
+
+func TestPipelineFeedsSequencedSecretsToIndependentSources(t *testing.T) {
+	cfg := map[string]interface{}{
+		"source_sequence": []interface{}{"doppler"},
+		"sources": map[string]interface{}{
+			"doppler": map[string]interface{}{
+				"project": "proj",
+				"env":     "dev",
+			},
+			"onepassword": map[string]interface{}{
+				"secrets": map[string]interface{}{
+					"API_KEY": "op://vault/item/password",
+					"SHARED":  "op://vault/item/shared",
+				},
+			},
+			"bitwarden": map[string]interface{}{
+				"secrets": map[string]interface{}{
+					"DB_PASSWORD": "123",
+				},
+			},
+		},
+	}
+
+	cleanup := withPatchedGlobals(func(name string, env []string, args ...string) ([]byte, error) {
+		switch name {
+		case "doppler":
+			return []byte(`{"OP_SERVICE_ACCOUNT_TOKEN":{"computed":"op-token"},"BWS_ACCESS_TOKEN":{"computed":"bws-token"},"SHARED":{"computed":"doppler"}}`), nil
+		case "op":
+			if !hasEnvVar(env, "OP_SERVICE_ACCOUNT_TOKEN", "op-token") {
+				return nil, fmt.Errorf("op did not receive token from doppler")
+			}
+			if args[1] == "op://vault/item/shared" {
+				return []byte("onepassword"), nil
+			}
+			return []byte("api"), nil
+		case "bws":
+			if !hasEnvVar(env, "BWS_ACCESS_TOKEN", "bws-token") {
+				return nil, fmt.Errorf("bws did not receive token from doppler")
+			}
+			return []byte(`{"id":"123","key":"DB_PASSWORD","value":"pass"}`), nil
+		}
+		return nil, fmt.Errorf("unexpected binary %s", name)
+	}, func(string) (string, error) {
+		return "/usr/bin/mock", nil
+	})
+	defer cleanup()
+
+	pipeline, err := NewPipeline(cfg)
+	if err != nil {
+		t.Fatalf("NewPipeline failed: %v", err)
+	}
+	if pipeline.Len() != 3 {
+		t.Fatalf("expected 3 sources, got %d", pipeline.Len())
+	}
+
+	secrets, _, err := pipeline.Fetch()
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+
+	expected := map[string]string{
+		"OP_SERVICE_ACCOUNT_TOKEN": "op-token",
+		"BWS_ACCESS_TOKEN":         "bws-token",
+		"API_KEY":                  "api",
+		"DB_PASSWORD":              "pass",
+		"SHARED":                   "onepassword",
+	}
+	if len(secrets.Entries) != len(expected) {
+		t.Fatalf("unexpected secrets count: got %d want %d", len(secrets.Entries), len(expected))
+	}
+	for key, value := range expected {
+		if got := secrets.Entries[key]; got != value {
+			t.Errorf("secret %s: got %q want %q", key, got, value)
+		}
+	}
+}
+
+func TestPipelineChainsSourcesAlphabeticallyWithoutSequence(t *testing.T) {
+	cfg := map[string]interface{}{
+		"sources": map[string]interface{}{
+			"doppler": map[string]interface{}{
+				"project": "proj",
+				"env":     "dev",
+			},
+			"onepassword": map[string]interface{}{
+				"secrets": map[string]interface{}{
+					"API_KEY": "op://vault/item/password",
+				},
+			},
+		},
+	}
+
+	cleanup := withPatchedGlobals(func(name string, env []string, args ...string) ([]byte, error) {
+		switch name {
+		case "doppler":
+			return []byte(`{"OP_SERVICE_ACCOUNT_TOKEN":{"computed":"op-token"}}`), nil
+		case "op":
+			if !hasEnvVar(env, "OP_SERVICE_ACCOUNT_TOKEN", "op-token") {
+				return nil, fmt.Errorf("op did not receive token from doppler")
+			}
+			return []byte("api"), nil
+		}
+		return nil, fmt.Errorf("unexpected binary %s", name)
+	}, func(string) (string, error) {
+		return "/usr/bin/mock", nil
+	})
+	defer cleanup()
+
+	pipeline, err := NewPipeline(cfg)
+	if err != nil {
+		t.Fatalf("NewPipeline failed: %v", err)
+	}
+
+	secrets, _, err := pipeline.Fetch()
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if got := secrets.Entries["API_KEY"]; got != "api" {
+		t.Errorf("API_KEY = %q, want %q", got, "api")
+	}
+}
+
+func TestForEachLimitCapsConcurrency(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, peak := 0, 0
+
+	err := forEachLimit(20, 3, func(int) error {
+		mu.Lock()
+		inFlight++
+		if inFlight > peak {
+			peak = inFlight
+		}
+		mu.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("forEachLimit failed: %v", err)
+	}
+	if peak > 3 {
+		t.Fatalf("expected at most 3 calls in flight, saw %d", peak)
+	}
+}
+
+func TestForEachLimitReturnsLowestIndexError(t *testing.T) {
+	for attempt := 0; attempt < 10; attempt++ {
+		err := forEachLimit(4, 4, func(i int) error {
+			if i == 1 {
+				time.Sleep(2 * time.Millisecond)
+				return errors.New("first")
+			}
+			if i == 3 {
+				return errors.New("second")
+			}
+			return nil
+		})
+		if err == nil || err.Error() != "first" {
+			t.Fatalf("expected error from lowest index, got %v", err)
+		}
+	}
+}
+
+func TestVaultSourceReadsKVv1AndKVv2(t *testing.T) {
+	cfg := map[string]interface{}{
+		"sources": map[string]interface{}{
+			"vault": map[string]interface{}{
+				"address": "http://127.0.0.1:8200",
+				"secrets": map[string]interface{}{
+					"DB_PASSWORD": "secret/app/database#password",
+					"DB_USER":     "secret/app/database#username",
+				},
+				"paths": []interface{}{"kv/legacy"},
+			},
+		},
+	}
+
+	var calls atomic.Int32
+	cleanup := withPatchedGlobals(func(name string, env []string, args ...string) ([]byte, error) {
+		if name != "vault" {
+			return nil, fmt.Errorf("unexpected binary %s", name)
+		}
+		if !hasEnvVar(env, "VAULT_ADDR", "http://127.0.0.1:8200") {
+			return nil, fmt.Errorf("missing VAULT_ADDR")
+		}
+		if len(args) != 4 || args[0] != "kv" || args[1] != "get" {
+			return nil, fmt.Errorf("unexpected args %v", args)
+		}
+		calls.Add(1)
+
+		switch args[3] {
+		case "secret/app/database":
+			return []byte(`{"data":{"data":{"password":"hunter2","username":"app"},"metadata":{"version":3}}}`), nil
+		case "kv/legacy":
+			return []byte(`{"data":{"LEGACY_TOKEN":"legacy","PORT":5432}}`), nil
+		}
+		return nil, fmt.Errorf("unexpected path %s", args[3])
+	}, func(string) (string, error) {
+		return "/usr/bin/vault", nil
+	})
+	defer cleanup()
+
+	pipeline, err := NewPipeline(cfg)
+	if err != nil {
+		t.Fatalf("NewPipeline failed: %v", err)
+	}
+
+	secrets, _, err := pipeline.Fetch()
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+
+	if calls.Load() != 2 {
+		t.Fatalf("expected each path to be read once, got %d reads", calls.Load())
+	}
+
+	expected := map[string]string{
+		"DB_PASSWORD":  "hunter2",
+		"DB_USER":      "app",
+		"LEGACY_TOKEN": "legacy",
+		"PORT":         "5432",
+	}
+	if len(secrets.Entries) != len(expected) {
+		t.Fatalf("unexpected secrets count: got %d want %d", len(secrets.Entries), len(expected))
+	}
+	for key, value := range expected {
+		if got := secrets.Entries[key]; got != value {
+			t.Errorf("secret %s: got %q want %q", key, got, value)
+		}
+	}
+}
+
+func TestVaultSourceLogsInWithAppRole(t *testing.T) {
+	cfg := map[string]interface{}{
+		"sources": map[string]interface{}{
+			"vault": map[string]interface{}{
+				"secrets": map[string]interface{}{
+					"API_KEY": "secret/app#api_key",
+				},
+			},
+		},
+	}
+
+	cleanup := withPatchedGlobals(nil, func(string) (string, error) {
+		return "/usr/bin/vault", nil
+	})
+	defer cleanup()
+	runCLICommand = func(_ context.Context, name string, env []string, stdin string, args ...string) ([]byte, error) {
+		if args[0] == "write" {
+			if args[2] != "auth/approle/login" || args[3] != "role_id=role" || args[4] != "secret_id=-" {
+				return nil, fmt.Errorf("unexpected login args %v", args)
+			}
+			if stdin != "sid" {
+				return nil, fmt.Errorf("expected the secret ID on stdin, got %q", stdin)
+			}
+			return []byte(`{"auth":{"client_token":"s.token"}}`), nil
+		}
+		if !hasEnvVar(env, "VAULT_TOKEN", "s.token") {
+			return nil, fmt.Errorf("read did not use approle token")
+		}
+		return []byte(`{"data":{"data":{"api_key":"key"},"metadata":{}}}`), nil
+	}
+
+	vault := NewVault()
+	if err := vault.Init(cfg); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+
+	previous := secret.New()
+	previous.Entries["VAULT_ROLE_ID"] = "role"
+	previous.Entries["VAULT_SECRET_ID"] = "sid"
+
+	secrets, err := vault.GetAllSecrets(previous)
+	if err != nil {
+		t.Fatalf("GetAllSecrets failed: %v", err)
+	}
+	if got := secrets.Entries["API_KEY"]; got != "key" {
+		t.Fatalf("API_KEY: got %q want %q", got, "key")
+	}
+}
+
+func TestVaultAppRoleLoginIsNotRetried(t *testing.T) {
+	cfg := map[string]interface{}{
+		"sources": map[string]interface{}{
+			"vault": map[string]interface{}{
+				"auth_method": "approle",
+				"secrets":     map[string]interface{}{"API_KEY": "secret/app#api_key"},
+			},
+		},
+	}
+
+	logins := 0
+	cleanup := withPatchedGlobals(func(name string, env []string, args ...string) ([]byte, error) {
+		logins++
+		return nil, &commandError{name: name, args: args, exitCode: 2, output: "503 Service Unavailable", err: errors.New("exit status 2")}
+	}, func(string) (string, error) {
+		return "/usr/bin/vault", nil
+	})
+	defer cleanup()
+	delays, restore := withRecordedSleeps()
+	defer restore()
+
+	vault := NewVault()
+	if err := vault.Init(cfg); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	previous := secret.New()
+	previous.Entries["VAULT_ROLE_ID"] = "role"
+	previous.Entries["VAULT_SECRET_ID"] = "sid"
+
+	if _, err := vault.GetAllSecrets(previous); err == nil {
+		t.Fatal("expected the login to fail")
+	}
+	if logins != 1 || len(*delays) != 0 {
+		t.Fatalf("expected a single login attempt, got %d attempts and delays %v", logins, *delays)
+	}
+}
+
+func TestVaultInitValidations(t *testing.T) {
+	cleanup := withPatchedGlobals(nil, func(string) (string, error) { return "/usr/bin/vault", nil })
+	defer cleanup()
+
+	cases := []map[string]interface{}{
+		{"secrets": map[string]interface{}{}},
+		{"secrets": map[string]interface{}{"MISSING_FIELD": "secret/app"}},
+		{"paths": []interface{}{""}},
+		{"paths": []interface{}{"secret/app"}, "auth_method": "ldap"},
+	}
+
+	for _, vaultConfig := range cases {
+		cfg := map[string]interface{}{
+			"sources": map[string]interface{}{"vault": vaultConfig},
+		}
+		if _, err := LoadAll(cfg); err == nil {
+			t.Fatalf("expected validation error for cfg %v", vaultConfig)
+		}
+	}
+}
+
+func TestAWSSourceFetchesSecretsAndParameters(t *testing.T) {
+	cfg := map[string]interface{}{
+		"sources": map[string]interface{}{
+			"aws": map[string]interface{}{
+				"profile": "prod",
+				"region":  "us-east-1",
+				"secrets": map[string]interface{}{
+					"DB_PASSWORD": "prod/db#password",
+					"API_TOKEN":   "arn:aws:secretsmanager:us-east-1:123:secret:api",
+				},
+				"secret_json": []interface{}{"prod/db"},
+				"parameters": map[string]interface{}{
+					"LOG_LEVEL": "/app/prod/log_level",
+				},
+				"parameter_paths": []interface{}{"/app/shared/"},
+			},
+		},
+	}
+
+	var secretCalls atomic.Int32
+	cleanup := withPatchedGlobals(func(name string, env []string, args ...string) ([]byte, error) {
+		if name != "aws" {
+			return nil, fmt.Errorf("unexpected binary %s", name)
+		}
+		joined := strings.Join(args, " ")
+		if !strings.HasSuffix(joined, "--profile prod --region us-east-1 --output json") {
+			return nil, fmt.Errorf("missing profile/region in %v", args)
+		}
+
+		switch {
+		case strings.HasPrefix(joined, "secretsmanager get-secret-value --secret-id prod/db "):
+			secretCalls.Add(1)
+			return []byte(`{"SecretString":"{\"password\":\"pw\",\"port\":5432}"}`), nil
+		case strings.HasPrefix(joined, "secretsmanager get-secret-value --secret-id arn:aws:secretsmanager:us-east-1:123:secret:api "):
+			return []byte(`{"SecretString":"plain-token"}`), nil
+		case strings.HasPrefix(joined, "ssm get-parameter --name /app/prod/log_level "):
+			return []byte(`{"Parameter":{"Name":"/app/prod/log_level","Value":"debug"}}`), nil
+		case strings.HasPrefix(joined, "ssm get-parameters-by-path --path /app/shared/ "):
+			return []byte(`{"Parameters":[{"Name":"/app/shared/REDIS_URL","Value":"redis://"},{"Name":"/app/shared/queue/NAME","Value":"jobs"}]}`), nil
+		}
+		return nil, fmt.Errorf("unexpected args %v", args)
+	}, func(string) (string, error) {
+		return "/usr/bin/aws", nil
+	})
+	defer cleanup()
+
+	pipeline, err := NewPipeline(cfg)
+	if err != nil {
+		t.Fatalf("NewPipeline failed: %v", err)
+	}
+
+	secrets, _, err := pipeline.Fetch()
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+
+	if secretCalls.Load() != 1 {
+		t.Fatalf("expected prod/db to be fetched once, got %d", secretCalls.Load())
+	}
+
+	expected := map[string]string{
+		"DB_PASSWORD": "pw",
+		"API_TOKEN":   "plain-token",
+		"password":    "pw",
+		"port":        "5432",
+		"LOG_LEVEL":   "debug",
+		"REDIS_URL":   "redis://",
+		"queue_NAME":  "jobs",
+	}
+	if len(secrets.Entries) != len(expected) {
+		t.Fatalf("unexpected secrets: got %v", secrets.Entries)
+	}
+	for key, value := range expected {
+		if got := secrets.Entries[key]; got != value {
+			t.Errorf("secret %s: got %q want %q", key, got, value)
+		}
+	}
+}
+
+func TestAWSSourceReportsMissingJSONKey(t *testing.T) {
+	cfg := map[string]interface{}{
+		"sources": map[string]interface{}{
+			"aws": map[string]interface{}{
+				"secrets": map[string]interface{}{
+					"DB_PASSWORD": "prod/db#missing",
+				},
+			},
+		},
+	}
+
+	cleanup := withPatchedGlobals(func(name string, env []string, args ...string) ([]byte, error) {
+		return []byte(`{"SecretString":"{\"password\":\"pw\"}"}`), nil
+	}, func(string) (string, error) {
+		return "/usr/bin/aws", nil
+	})
+	defer cleanup()
+
+	aws := NewAWS()
+	if err := aws.Init(cfg); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+
+	if _, err := aws.GetAllSecrets(secret.New()); err == nil || !strings.Contains(err.Error(), "no key missing") {
+		t.Fatalf("expected missing key error, got %v", err)
+	}
+}
+
+func TestAWSInitValidations(t *testing.T) {
+	cleanup := withPatchedGlobals(nil, func(string) (string, error) { return "/usr/bin/aws", nil })
+	defer cleanup()
+
+	cases := []map[string]interface{}{
+		{},
+		{"secrets": map[string]interface{}{"EMPTY": ""}},
+		{"secrets": map[string]interface{}{"NO_FIELD": "prod/db#"}},
+		{"parameter_paths": "/app/"},
+	}
+
+	for _, awsConfig := range cases {
+		cfg := map[string]interface{}{
+			"sources": map[string]interface{}{"aws": awsConfig},
+		}
+		if _, err := LoadAll(cfg); err == nil {
+			t.Fatalf("expected validation error for cfg %v", awsConfig)
+		}
+	}
+}
+
+func TestPipelineAppliesSourceFilter(t *testing.T) {
+	cfg := map[string]interface{}{
+		"sources": map[string]interface{}{
+			"doppler": map[string]interface{}{
+				"project": "proj",
+				"env":     "dev",
+				"filter": map[string]interface{}{
+					"exclude": []interface{}{"prefix:DOPPLER_"},
+				},
+			},
+		},
+	}
+
+	cleanup := withPatchedGlobals(func(name string, env []string, args ...string) ([]byte, error) {
+		return []byte(`{"DOPPLER_PROJECT":{"computed":"proj"},"API_KEY":{"computed":"key"}}`), nil
+	}, func(string) (string, error) {
+		return "/usr/bin/doppler", nil
+	})
+	defer cleanup()
+
+	pipeline, err := NewPipeline(cfg)
+	if err != nil {
+		t.Fatalf("NewPipeline failed: %v", err)
+	}
+
+	secrets, _, err := pipeline.Fetch()
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if len(secrets.Entries) != 1 || secrets.Entries["API_KEY"] != "key" {
+		t.Fatalf("unexpected secrets: %v", secrets.Entries)
+	}
+}
+
+func TestPipelineRejectsInvalidSourceFilter(t *testing.T) {
+	cfg := map[string]interface{}{
+		"sources": map[string]interface{}{
+			"doppler": map[string]interface{}{
+				"project": "proj",
+				"env":     "dev",
+				"filter": map[string]interface{}{
+					"include": []interface{}{"regex:("},
+				},
+			},
+		},
+	}
+
+	cleanup := withPatchedGlobals(nil, func(string) (string, error) { return "/usr/bin/doppler", nil })
+	defer cleanup()
+
+	if _, err := NewPipeline(cfg); err == nil {
+		t.Fatalf("expected error for invalid filter")
+	}
+}
+
+func TestPipelineAppliesSourceAndGlobalTransforms(t *testing.T) {
+	cfg := map[string]interface{}{
+		"transform": map[string]interface{}{
+			"add_prefix": "APP_",
+		},
+		"sources": map[string]interface{}{
+			"bitwarden": map[string]interface{}{
+				"secrets": map[string]interface{}{
+					"api-token": "123",
+				},
+				"transform": map[string]interface{}{
+					"replace":   map[string]interface{}{"-": "_"},
+					"uppercase": true,
+				},
+			},
+			"doppler": map[string]interface{}{
+				"project": "proj",
+				"env":     "dev",
+			},
+		},
+	}
+
+	cleanup := withPatchedGlobals(func(name string, env []string, args ...string) ([]byte, error) {
+		if name == "doppler" {
+			return []byte(`{"db.url":{"computed":"postgres://"}}`), nil
+		}
+		return []byte(`{"id":"123","key":"api-token","value":"token"}`), nil
+	}, func(string) (string, error) {
+		return "/usr/bin/mock", nil
+	})
+	defer cleanup()
+
+	pipeline, err := NewPipeline(cfg)
+	if err != nil {
+		t.Fatalf("NewPipeline failed: %v", err)
+	}
+
+	secrets, _, err := pipeline.Fetch()
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+
+	expected := map[string]string{
+		"APP_API_TOKEN": "token",
+		"APP_db_url":    "postgres://",
+	}
+	if len(secrets.Entries) != len(expected) {
+		t.Fatalf("unexpected secrets: %v", secrets.Entries)
+	}
+	for key, value := range expected {
+		if got := secrets.Entries[key]; got != value {
+			t.Errorf("secret %s: got %q want %q", key, got, value)
+		}
+	}
+}
+
+func TestPipelineRecordsProvenance(t *testing.T) {
+	cfg := map[string]interface{}{
+		"transform": map[string]interface{}{
+			"add_prefix": "APP_",
+		},
+		"sources": map[string]interface{}{
+			"bitwarden": map[string]interface{}{
+				"secrets": map[string]interface{}{
+					"TOKEN": "123",
+				},
+			},
+			"doppler": map[string]interface{}{
+				"project": "proj",
+				"env":     "dev",
+			},
+		},
+	}
+
+	cleanup := withPatchedGlobals(func(name string, env []string, args ...string) ([]byte, error) {
+		if name == "doppler" {
+			return []byte(`{"DB_URL":{"computed":"postgres://"}}`), nil
+		}
+		return []byte(`{"id":"123","key":"token","value":"token"}`), nil
+	}, func(string) (string, error) {
+		return "/usr/bin/mock", nil
+	})
+	defer cleanup()
+
+	pipeline, err := NewPipeline(cfg)
+	if err != nil {
+		t.Fatalf("NewPipeline failed: %v", err)
+	}
+
+	before := time.Now()
+	secrets, _, err := pipeline.Fetch()
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+
+	expected := map[string][2]string{
+		"APP_TOKEN":  {"bitwarden", "123"},
+		"APP_DB_URL": {"doppler", "proj/dev"},
+	}
+	for key, want := range expected {
+		meta, ok := secrets.Meta(key)
+		if !ok {
+			t.Fatalf("missing metadata for %s", key)
+		}
+		if meta.Source != want[0] || meta.Reference != want[1] {
+			t.Errorf("%s: got source %q reference %q, want %q %q", key, meta.Source, meta.Reference, want[0], want[1])
+		}
+		if meta.FetchedAt.Before(before) {
+			t.Errorf("%s: fetch time %v predates fetch", key, meta.FetchedAt)
+		}
+		if meta.Hash != secret.HashValue(secrets.Entries[key]) {
+			t.Errorf("%s: unexpected hash %q", key, meta.Hash)
+		}
+	}
+}
+
+func TestPipelineRefreshesOnlyExpiredSources(t *testing.T) {
+	cfg := map[string]interface{}{
+		"sources": map[string]interface{}{
+			"bitwarden": map[string]interface{}{
+				"secrets": map[string]interface{}{
+					"TOKEN": "123",
+				},
+				"ttl": "24h",
+			},
+			"doppler": map[string]interface{}{
+				"project": "proj",
+				"env":     "dev",
+				"ttl":     "10m",
+			},
+		},
+	}
+
+	var calls sync.Map
+	dopplerValue := "first"
+	cleanup := withPatchedGlobals(func(name string, env []string, args ...string) ([]byte, error) {
+		count, _ := calls.LoadOrStore(name, new(atomic.Int32))
+		count.(*atomic.Int32).Add(1)
+		if name == "doppler" {
+			return []byte(fmt.Sprintf(`{"DB_URL":{"computed":%q}}`, dopplerValue)), nil
+		}
+		return []byte(`{"id":"123","key":"token","value":"token"}`), nil
+	}, func(string) (string, error) {
+		return "/usr/bin/mock", nil
+	})
+	defer cleanup()
+
+	pipeline, err := NewPipeline(cfg)
+	if err != nil {
+		t.Fatalf("NewPipeline failed: %v", err)
+	}
+
+	cached, _, err := pipeline.Fetch()
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if stale := pipeline.Stale(cached, time.Hour); len(stale) != 0 {
+		t.Fatalf("expected a fresh cache, got stale sources %v", stale)
+	}
+
+	// Age the doppler entries past their 10m ttl but not past the default.
+	fetch := cached.Fetches["doppler"]
+	fetch.FetchedAt = fetch.FetchedAt.Add(-30 * time.Minute)
+	cached.Fetches["doppler"] = fetch
+
+	stale := pipeline.Stale(cached, time.Hour)
+	if len(stale) != 1 || stale[0] != "doppler" {
+		t.Fatalf("expected only doppler to be stale, got %v", stale)
+	}
+
+	dopplerValue = "second"
+	secrets, _, err := pipeline.Refresh(cached, time.Hour)
+	if err != nil {
+		t.Fatalf("Refresh failed: %v", err)
+	}
+
+	if secrets.Entries["DB_URL"] != "second" || secrets.Entries["TOKEN"] != "token" {
+		t.Fatalf("unexpected secrets: %v", secrets.Entries)
+	}
+	for name, want := range map[string]int32{"doppler": 2, "bws": 1} {
+		count, _ := calls.Load(name)
+		if got := count.(*atomic.Int32).Load(); got != want {
+			t.Errorf("%s called %d times, want %d", name, got, want)
+		}
+	}
+	if !secrets.Fetches["bitwarden"].FetchedAt.Equal(cached.Fetches["bitwarden"].FetchedAt) {
+		t.Errorf("expected reused source to keep its original fetch time")
+	}
+	if meta, _ := secrets.Meta("TOKEN"); meta.Source != "bitwarden" {
+		t.Errorf("expected reused entry to keep its metadata, got %+v", meta)
+	}
+}
+
+func TestPipelineRefetchesSourcesMissingFromCache(t *testing.T) {
+	cfg := map[string]interface{}{
+		"sources": map[string]interface{}{
+			"doppler": map[string]interface{}{
+				"project": "proj",
+				"env":     "dev",
+			},
+		},
+	}
+
+	cleanup := withPatchedGlobals(func(name string, env []string, args ...string) ([]byte, error) {
+		return []byte(`{"DB_URL":{"computed":"postgres://"}}`), nil
+	}, func(string) (string, error) {
+		return "/usr/bin/doppler", nil
+	})
+	defer cleanup()
+
+	pipeline, err := NewPipeline(cfg)
+	if err != nil {
+		t.Fatalf("NewPipeline failed: %v", err)
+	}
+
+	// A cache written before fetch times were recorded refreshes everything.
+	legacy := secret.New()
+	legacy.Entries["DB_URL"] = "postgres://"
+	if stale := pipeline.Stale(legacy, time.Hour); len(stale) != 1 {
+		t.Fatalf("expected legacy cache to be stale, got %v", stale)
+	}
+
+	// So does a cache that lost one of the source's keys.
+	cached, _, err := pipeline.Fetch()
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	delete(cached.Entries, "DB_URL")
+	delete(cached.Metadata, "DB_URL")
+	if stale := pipeline.Stale(cached, time.Hour); len(stale) != 1 {
+		t.Fatalf("expected incomplete cache to be stale, got %v", stale)
+	}
+}
+
+func TestPipelineRejectsInvalidSourceTTL(t *testing.T) {
+	for _, ttl := range []interface{}{"soon", "-1h", 60} {
+		cfg := map[string]interface{}{
+			"sources": map[string]interface{}{
+				"doppler": map[string]interface{}{
+					"project": "proj",
+					"env":     "dev",
+					"ttl":     ttl,
+				},
+			},
+		}
+
+		cleanup := withPatchedGlobals(nil, func(string) (string, error) {
+			return "/usr/bin/doppler", nil
+		})
+		_, err := NewPipeline(cfg)
+		cleanup()
+		if err == nil || !strings.Contains(err.Error(), "ttl") {
+			t.Errorf("ttl %v: expected ttl error, got %v", ttl, err)
+		}
+	}
+}
+
+func TestPipelineRejectsInvalidNamesWhenConfigured(t *testing.T) {
+	cfg := map[string]interface{}{
+		"transform": map[string]interface{}{
+			"invalid_names": "error",
+		},
+		"sources": map[string]interface{}{
+			"doppler": map[string]interface{}{
+				"project": "proj",
+				"env":     "dev",
+			},
+		},
+	}
+
+	cleanup := withPatchedGlobals(func(name string, env []string, args ...string) ([]byte, error) {
+		return []byte(`{"api-token":{"computed":"token"}}`), nil
+	}, func(string) (string, error) {
+		return "/usr/bin/doppler", nil
+	})
+	defer cleanup()
+
+	pipeline, err := NewPipeline(cfg)
+	if err != nil {
+		t.Fatalf("NewPipeline failed: %v", err)
+	}
+
+	if _, _, err := pipeline.Fetch(); err == nil || !strings.Contains(err.Error(), "not a valid environment variable name") {
+		t.Fatalf("expected invalid name error, got %v", err)
+	}
+}
+
+func collisionTestConfig(policy string) map[string]interface{} {
+	cfg := map[string]interface{}{
+		"source_sequence": []interface{}{"doppler", "onepassword"},
+		"sources": map[string]interface{}{
+			"doppler": map[string]interface{}{
+				"project": "proj",
+				"env":     "dev",
+			},
+			"onepassword": map[string]interface{}{
+				"secrets": map[string]interface{}{
+					"API_KEY": "op://vault/item/password",
+				},
+			},
+		},
+	}
+	if policy != "" {
+		cfg["collision_policy"] = policy
+	}
+	return cfg
+}
+
+func patchCollisionSources() func() {
+	return withPatchedGlobals(func(name string, env []string, args ...string) ([]byte, error) {
+		if name == "doppler" {
+			return []byte(`{"API_KEY":{"computed":"from-doppler"},"OTHER":{"computed":"other"}}`), nil
+		}
+		return []byte("from-onepassword"), nil
+	}, func(string) (string, error) {
+		return "/usr/bin/mock", nil
+	})
+}
+
+func TestPipelineCollisionPolicies(t *testing.T) {
+	defer patchCollisionSources()()
+
+	tests := []struct {
+		policy string
+		value  string
+		winner string
+		loser  string
+	}{
+		{"", "from-onepassword", "onepassword", "doppler"},
+		{CollisionLastWins, "from-onepassword", "onepassword", "doppler"},
+		{CollisionWarn, "from-onepassword", "onepassword", "doppler"},
+		{CollisionFirstWins, "from-doppler", "doppler", "onepassword"},
+	}
+
+	for _, tt := range tests {
+		pipeline, err := NewPipeline(collisionTestConfig(tt.policy))
+		if err != nil {
+			t.Fatalf("NewPipeline(%q) failed: %v", tt.policy, err)
+		}
+
+		secrets, report, err := pipeline.Fetch()
+		if err != nil {
+			t.Fatalf("Fetch(%q) failed: %v", tt.policy, err)
+		}
+
+		if got := secrets.Entries["API_KEY"]; got != tt.value {
+			t.Errorf("policy %q: API_KEY = %q, want %q", tt.policy, got, tt.value)
+		}
+		if got := report.Origins["API_KEY"]; got != tt.winner {
+			t.Errorf("policy %q: origin = %q, want %q", tt.policy, got, tt.winner)
+		}
+		if got := report.Overrode("API_KEY"); len(got) != 1 || got[0] != tt.loser {
+			t.Errorf("policy %q: overrode = %v, want [%s]", tt.policy, got, tt.loser)
+		}
+		if got := report.Origins["OTHER"]; got != "doppler" {
+			t.Errorf("policy %q: OTHER origin = %q, want doppler", tt.policy, got)
+		}
+	}
+}
+
+func TestPipelineReusesCacheAfterCollision(t *testing.T) {
+	var calls int32
+	cleanup := withPatchedGlobals(func(name string, env []string, args ...string) ([]byte, error) {
+		atomic.AddInt32(&calls, 1)
+		if name == "doppler" {
+			return []byte(`{"API_KEY":{"computed":"from-doppler"},"OTHER":{"computed":"other"}}`), nil
+		}
+		return []byte("from-onepassword"), nil
+	}, func(string) (string, error) {
+		return "/usr/bin/mock", nil
+	})
+	defer cleanup()
+
+	pipeline, err := NewPipeline(collisionTestConfig(""))
+	if err != nil {
+		t.Fatalf("NewPipeline failed: %v", err)
+	}
+	cached, _, err := pipeline.Fetch()
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if stale := pipeline.Stale(cached, time.Hour); len(stale) != 0 {
+		t.Fatalf("expected a fresh cache, got stale sources %v", stale)
+	}
+
+	atomic.StoreInt32(&calls, 0)
+	secrets, _, err := pipeline.Refresh(cached, time.Hour)
+	if err != nil {
+		t.Fatalf("Refresh failed: %v", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 0 {
+		t.Fatalf("expected no CLI calls on the second run, got %d", n)
+	}
+	if secrets.Entries["API_KEY"] != "from-onepassword" || secrets.Entries["OTHER"] != "other" {
+		t.Fatalf("unexpected secrets from cache: %v", secrets.Entries)
+	}
+}
+
+func TestPipelineCollisionPolicyError(t *testing.T) {
+	defer patchCollisionSources()()
+
+	pipeline, err := NewPipeline(collisionTestConfig(CollisionError))
+	if err != nil {
+		t.Fatalf("NewPipeline failed: %v", err)
+	}
+
+	_, _, err = pipeline.Fetch()
+	if err == nil || !strings.Contains(err.Error(), "provided by both doppler and onepassword") {
+		t.Fatalf("expected collision error, got %v", err)
+	}
+
+	if _, err := NewPipeline(collisionTestConfig("random")); err == nil {
+		t.Fatalf("expected error for unknown collision policy")
+	}
+}
+
+// withRecordedSleeps replaces the retry sleep with one that only records the
+// requested delays.
+func withRecordedSleeps() (*[]time.Duration, func()) {
+	var delays []time.Duration
+	var mu sync.Mutex
+	original := sleep
+	sleep = func(d time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		delays = append(delays, d)
+	}
+	return &delays, func() { sleep = original }
+}
+
+func TestSourceRetriesTransientFailures(t *testing.T) {
+	cfg := map[string]interface{}{
+		"sources": map[string]interface{}{
+			"onepassword": map[string]interface{}{
+				"secrets": map[string]interface{}{
+					"API_KEY": "op://vault/item/password",
+				},
+				"retry": map[string]interface{}{
+					"attempts":    float64(4),
+					"backoff":     "100ms",
+					"max_backoff": "300ms",
+				},
+			},
+		},
+	}
+
+	var calls atomic.Int32
+	cleanup := withPatchedGlobals(func(name string, env []string, args ...string) ([]byte, error) {
+		if calls.Add(1) < 4 {
+			return nil, &commandError{name: name, args: args, exitCode: 1, output: "[ERROR] 429 Too Many Requests", err: errors.New("exit status 1")}
+		}
+		return []byte("secret-value\n"), nil
+	}, func(string) (string, error) {
+		return "/usr/bin/op", nil
+	})
+	defer cleanup()
+	delays, restore := withRecordedSleeps()
+	defer restore()
+
+	pipeline, err := NewPipeline(cfg)
+	if err != nil {
+		t.Fatalf("NewPipeline failed: %v", err)
+	}
+
+	secrets, _, err := pipeline.Fetch()
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if secrets.Entries["API_KEY"] != "secret-value" {
+		t.Fatalf("unexpected secrets: %v", secrets.Entries)
+	}
+	if got := calls.Load(); got != 4 {
+		t.Fatalf("expected 4 attempts, got %d", got)
+	}
+
+	// Backoff doubles from 100ms and is capped at 300ms, with up to half of
+	// each delay taken off as jitter.
+	bounds := [][2]time.Duration{
+		{50 * time.Millisecond, 100 * time.Millisecond},
+		{100 * time.Millisecond, 200 * time.Millisecond},
+		{150 * time.Millisecond, 300 * time.Millisecond},
+	}
+	if len(*delays) != len(bounds) {
+		t.Fatalf("expected %d sleeps, got %v", len(bounds), *delays)
+	}
+	for i, delay := range *delays {
+		if delay < bounds[i][0] || delay > bounds[i][1] {
+			t.Errorf("delay %d: %v not within %v", i, delay, bounds[i])
+		}
+	}
+}
+
+func TestSourceDoesNotRetryPermanentFailures(t *testing.T) {
+	cfg := map[string]interface{}{
+		"sources": map[string]interface{}{
+			"doppler": map[string]interface{}{
+				"project": "proj",
+				"env":     "dev",
+			},
+		},
+	}
+
+	var calls atomic.Int32
+	cleanup := withPatchedGlobals(func(name string, env []string, args ...string) ([]byte, error) {
+		calls.Add(1)
+		return nil, &commandError{name: name, args: args, exitCode: 1, output: "Invalid auth token", err: errors.New("exit status 1")}
+	}, func(string) (string, error) {
+		return "/usr/bin/doppler", nil
+	})
+	defer cleanup()
+	delays, restore := withRecordedSleeps()
+	defer restore()
+
+	pipeline, err := NewPipeline(cfg)
+	if err != nil {
+		t.Fatalf("NewPipeline failed: %v", err)
+	}
+	if _, _, err := pipeline.Fetch(); err == nil {
+		t.Fatal("expected fetch to fail")
+	}
+	if got := calls.Load(); got != 1 {
+		t.Fatalf("expected a single attempt, got %d", got)
+	}
+	if len(*delays) != 0 {
+		t.Fatalf("expected no backoff, got %v", *delays)
+	}
+}
+
+func TestSourceRetryIgnoresPatternsInArguments(t *testing.T) {
+	cfg := map[string]interface{}{
+		"sources": map[string]interface{}{
+			"onepassword": map[string]interface{}{
+				"secrets": map[string]interface{}{
+					"API_KEY": "op://vault/4f2a429c-rate-limit/password",
+				},
+			},
+		},
+	}
+
+	var calls atomic.Int32
+	cleanup := withPatchedGlobals(func(name string, env []string, args ...string) ([]byte, error) {
+		calls.Add(1)
+		return nil, &commandError{name: name, args: args, exitCode: 1, output: "[ERROR] item not found", err: errors.New("exit status 1")}
+	}, func(string) (string, error) {
+		return "/usr/bin/op", nil
+	})
+	defer cleanup()
+	_, restore := withRecordedSleeps()
+	defer restore()
+
+	pipeline, err := NewPipeline(cfg)
+	if err != nil {
+		t.Fatalf("NewPipeline failed: %v", err)
+	}
+	if _, _, err := pipeline.Fetch(); err == nil {
+		t.Fatal("expected fetch to fail")
+	}
+	if got := calls.Load(); got != 1 {
+		t.Fatalf("expected a single attempt, got %d", got)
+	}
+}
+
+func TestSourceRetryGivesUpAfterAttempts(t *testing.T) {
+	cfg := map[string]interface{}{
+		"sources": map[string]interface{}{
+			"doppler": map[string]interface{}{
+				"project": "proj",
+				"env":     "dev",
+				"retry": map[string]interface{}{
+					"attempts":   2,
+					"exit_codes": []interface{}{float64(42)},
+				},
+			},
+		},
+	}
+
+	var calls atomic.Int32
+	cleanup := withPatchedGlobals(func(name string, env []string, args ...string) ([]byte, error) {
+		calls.Add(1)
+		return nil, &commandError{name: name, args: args, exitCode: 42, err: errors.New("exit status 42")}
+	}, func(string) (string, error) {
+		return "/usr/bin/doppler", nil
+	})
+	defer cleanup()
+	_, restore := withRecordedSleeps()
+	defer restore()
+
+	pipeline, err := NewPipeline(cfg)
+	if err != nil {
+		t.Fatalf("NewPipeline failed: %v", err)
+	}
+	_, _, err = pipeline.Fetch()
+	var cmdErr *commandError
+	if !errors.As(err, &cmdErr) || cmdErr.exitCode != 42 {
+		t.Fatalf("expected the last command error, got %v", err)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Fatalf("expected 2 attempts, got %d", got)
+	}
+}
+
+func TestSourceTimeoutAppliesPerAttempt(t *testing.T) {
+	cfg := map[string]interface{}{
+		"sources": map[string]interface{}{
+			"doppler": map[string]interface{}{
+				"project": "proj",
+				"env":     "dev",
+				"timeout": "2s",
+			},
+		},
+	}
+
+	cleanup := withPatchedGlobals(nil, func(string) (string, error) {
+		return "/usr/bin/doppler", nil
+	})
+	defer cleanup()
+
+	var remaining time.Duration
+	runCLICommand = func(ctx context.Context, name string, env []string, _ string, args ...string) ([]byte, error) {
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			return nil, errors.New("expected a deadline")
+		}
+		remaining = time.Until(deadline)
+		return []byte(`{}`), nil
+	}
+
+	pipeline, err := NewPipeline(cfg)
+	if err != nil {
+		t.Fatalf("NewPipeline failed: %v", err)
+	}
+	if _, _, err := pipeline.Fetch(); err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if remaining <= 0 || remaining > 2*time.Second {
+		t.Fatalf("expected a 2s deadline, got %v remaining", remaining)
+	}
+}
+
+func TestDefaultRunCLICommandReportsTimeouts(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := defaultRunCLICommand(ctx, "sleep", os.Environ(), "", "5")
+	var cmdErr *commandError
+	if !errors.As(err, &cmdErr) || !cmdErr.timedOut {
+		t.Fatalf("expected a timed out command error, got %v", err)
+	}
+
+	if !newCLIRunner(CommonConfig{}).retry.retryable(err) {
+		t.Fatal("expected timeouts to be retryable")
+	}
+}
+
+func TestSourceRejectsInvalidRetryConfig(t *testing.T) {
+	invalid := []map[string]interface{}{
+		{"timeout": "soon"},
+		{"retry": "always"},
+		{"retry": map[string]interface{}{"attempts": float64(0)}},
+		{"retry": map[string]interface{}{"attempts": 1.5}},
+		{"retry": map[string]interface{}{"backoff": "2s", "max_backoff": "1s"}},
+		{"retry": map[string]interface{}{"exit_codes": []interface{}{"75"}}},
+		{"retry": map[string]interface{}{"patterns": []interface{}{""}}},
+	}
+
+	for _, extra := range invalid {
+		dopplerConfig := map[string]interface{}{"project": "proj", "env": "dev"}
+		for key, value := range extra {
+			dopplerConfig[key] = value
+		}
+		cfg := map[string]interface{}{
+			"sources": map[string]interface{}{"doppler": dopplerConfig},
+		}
+
+		cleanup := withPatchedGlobals(nil, func(string) (string, error) {
+			return "/usr/bin/doppler", nil
+		})
+		_, err := LoadAll(cfg)
+		cleanup()
+		if err == nil || !strings.Contains(err.Error(), "$.sources.doppler.") {
+			t.Errorf("expected %v to be rejected with its path, got %v", extra, err)
+		}
+	}
+}
+
+func TestPipelineRegistersFetchedValuesForRedaction(t *testing.T) {
+	redact.Reset()
+	defer redact.Reset()
+
+	cfg := map[string]interface{}{
+		"source_sequence": []interface{}{"doppler", "onepassword"},
+		"sources": map[string]interface{}{
+			"doppler": map[string]interface{}{
+				"project": "proj",
+				"env":     "dev",
+			},
+			"onepassword": map[string]interface{}{
+				"secrets": map[string]interface{}{
+					"API_KEY": "op://vault/item/password",
+				},
+			},
+		},
+	}
+
+	cleanup := withPatchedGlobals(func(name string, env []string, args ...string) ([]byte, error) {
+		if name == "doppler" {
+			return []byte(`{"OP_SERVICE_ACCOUNT_TOKEN":{"computed":"ops_token_value"}}`), nil
+		}
+		return nil, fmt.Errorf("op read: invalid token %q", envValue(env, "OP_SERVICE_ACCOUNT_TOKEN"))
+	}, func(string) (string, error) {
+		return "/usr/bin/mock", nil
+	})
+	defer cleanup()
+
+	pipeline, err := NewPipeline(cfg)
+	if err != nil {
+		t.Fatalf("NewPipeline failed: %v", err)
+	}
+
+	_, _, err = pipeline.Fetch()
+	if err == nil {
+		t.Fatal("expected fetch to fail")
+	}
+	if !strings.Contains(err.Error(), "ops_token_value") {
+		t.Fatalf("expected the raw error to carry the value, got %v", err)
+	}
+	if masked := redact.String(err.Error()); strings.Contains(masked, "ops_token_value") {
+		t.Fatalf("expected fetched value to be redacted, got %s", masked)
+	}
+}
+
+func TestCommandErrorsLeaveOutStdoutAndMaskCredentials(t *testing.T) {
+	redact.Reset()
+	defer redact.Reset()
+	t.Setenv("VAULT_SECRET_ID", "sid-from-environment")
+	t.Setenv("PARTIAL_DUMP", `{"API_KEY":"partial-dump"}`)
+	buildCommandEnv(nil)
+
+	_, err := defaultRunCLICommand(context.Background(), "sh", os.Environ(), "",
+		"-c", `echo "$PARTIAL_DUMP"; echo "login failed for $VAULT_SECRET_ID" >&2; exit 1`)
+	var cmdErr *commandError
+	if !errors.As(err, &cmdErr) || cmdErr.exitCode != 1 {
+		t.Fatalf("expected a command error, got %v", err)
+	}
+	if message := err.Error(); strings.Contains(message, "partial-dump") || strings.Contains(message, "sid-from-environment") ||
+		!strings.Contains(message, "login failed for "+redact.Mask) {
+		t.Fatalf("expected stdout to be left out and the credential masked, got %q", message)
+	}
+}
+
+func TestStatusesReportEachSource(t *testing.T) {
+	cfg := map[string]interface{}{
+		"sources": map[string]interface{}{
+			"doppler": map[string]interface{}{
+				"project": "proj",
+				"env":     "dev",
+				"ttl":     "15m",
+			},
+			"onepassword": map[string]interface{}{
+				"secrets": map[string]interface{}{"API_KEY": "op://vault/item/password"},
+			},
+			"unknown": map[string]interface{}{},
+		},
+	}
+
+	cleanup := withPatchedGlobals(nil, func(name string) (string, error) {
+		if name == "op" {
+			return "", errors.New("not found")
+		}
+		return "/usr/bin/" + name, nil
+	})
+	defer cleanup()
+
+	statuses := Statuses(cfg)
+	if len(statuses) != 3 {
+		t.Fatalf("expected 3 statuses, got %+v", statuses)
+	}
+	if s := statuses[0]; s.Name != "doppler" || !s.Enabled || s.Err != nil || s.TTL != 15*time.Minute {
+		t.Errorf("unexpected doppler status %+v", s)
+	}
+	if s := statuses[1]; s.Name != "onepassword" || s.Enabled || s.Err == nil {
+		t.Errorf("expected missing op CLI to be reported, got %+v", s)
+	}
+	if s := statuses[2]; s.Name != "unknown" || s.Err == nil {
+		t.Errorf("expected unknown source to be reported, got %+v", s)
+	}
+}
+
+func TestDiagnoseChecksCLIAndAuth(t *testing.T) {
+	var authArgs []string
+	restore := withPatchedGlobals(func(name string, env []string, args ...string) ([]byte, error) {
+		switch {
+		case name == "doppler" && args[0] == "--version":
+			return []byte("v3.68.0\n"), nil
+		case name == "doppler":
+			return []byte(`{"name":"me"}`), nil
+		case name == "aws" && args[0] == "--version":
+			return []byte("aws-cli/2.15.0 Python/3.11\n"), nil
+		case name == "aws":
+			authArgs = args
+			return nil, &commandError{name: name, args: args, exitCode: 255, output: "Unable to locate credentials", err: errors.New("exit status 255")}
+		}
+		return nil, fmt.Errorf("unexpected command %s %v", name, args)
+	}, func(name string) (string, error) {
+		if name == "op" {
+			return "", errors.New("not found")
+		}
+		return "/usr/bin/" + name, nil
+	})
+	defer restore()
+
+	cfg := map[string]interface{}{
+		"source_sequence": []interface{}{"doppler"},
+		"sources": map[string]interface{}{
+			"doppler":     map[string]interface{}{"project": "proj", "env": "dev"},
+			"aws":         map[string]interface{}{"secrets": map[string]interface{}{"DB_PASSWORD": "prod/db"}, "profile": "prod"},
+			"onepassword": map[string]interface{}{"secrets": map[string]interface{}{"TOKEN": "op://vault/item/field"}},
+		},
+	}
+
+	checks := Diagnose(cfg)
+	results := make(map[string]Check, len(checks))
+	for _, check := range checks {
+		results[check.Name] = check
+	}
+
+	if check := results["doppler: version"]; check.Result != CheckPass || check.Detail != "v3.68.0" {
+		t.Errorf("unexpected doppler version check %+v", check)
+	}
+	if check := results["doppler: authenticated"]; check.Result != CheckPass {
+		t.Errorf("unexpected doppler auth check %+v", check)
+	}
+	// aws comes after a sequenced source that may supply its credentials.
+	if check := results["aws: authenticated"]; check.Result != CheckWarn || !strings.Contains(check.Detail, "Unable to locate credentials") {
+		t.Errorf("expected aws auth warning, got %+v", check)
+	}
+	if strings.Join(authArgs, " ") != "sts get-caller-identity --profile prod" {
+		t.Errorf("unexpected aws auth args %v", authArgs)
+	}
+	if check := results["onepassword: op CLI"]; check.Result != CheckFail || check.Hint == "" {
+		t.Errorf("expected missing op CLI to fail with a hint, got %+v", check)
+	}
+	if _, ok := results["onepassword: config"]; ok {
+		t.Errorf("expected checks after a missing CLI to be skipped")
+	}
+	if checks[0].Name != "doppler: doppler CLI" {
+		t.Errorf("expected checks in fetch order, got %+v", checks)
+	}
+}
+
+func TestDiagnoseReportsInvalidSourceConfig(t *testing.T) {
+	restore := withPatchedGlobals(func(name string, env []string, args ...string) ([]byte, error) {
+		t.Fatalf("unexpected command %s %v", name, args)
+		return nil, nil
+	}, func(name string) (string, error) { return "/usr/bin/" + name, nil })
+	defer restore()
+
+	checks := Diagnose(map[string]interface{}{
+		"sources": map[string]interface{}{
+			"doppler": map[string]interface{}{"project": "proj"},
+			"nope":    map[string]interface{}{},
+		},
+	})
+
+	if len(checks) != 3 {
+		t.Fatalf("expected 3 checks, got %+v", checks)
+	}
+	if checks[1].Name != "doppler: config" || checks[1].Result != CheckFail || checks[1].Detail != "$.sources.doppler.env: is required" {
+		t.Errorf("expected doppler config failure, got %+v", checks[1])
+	}
+	if checks[2].Name != "nope" || checks[2].Result != CheckFail {
+		t.Errorf("expected unknown source failure, got %+v", checks[2])
+	}
+}
@@ -0,0 +1,281 @@
+package source
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/napisani/secret_inject/internal/redact"
+	"github.com/napisani/secret_inject/internal/secret"
+)
+
+// VaultConfig is the "vault" source block.
+type VaultConfig struct {
+	CommonConfig
+	Secrets      map[string]vaultReference `json:"secrets,omitempty" schema:"pattern=^[^#]+#.+$" doc:"Environment variable names mapped to 'path#field' references."`
+	Paths        []string                  `json:"paths,omitempty" schema:"nonempty" doc:"Secret paths whose fields are all loaded."`
+	Address      string                    `json:"address,omitempty" doc:"Vault server address, overriding VAULT_ADDR."`
+	Namespace    string                    `json:"namespace,omitempty" doc:"Vault Enterprise namespace, overriding VAULT_NAMESPACE."`
+	AuthMethod   string                    `json:"auth_method,omitempty" schema:"enum=token|approle" doc:"How to authenticate; by default AppRole is used when VAULT_ROLE_ID and VAULT_SECRET_ID are set and VAULT_TOKEN is not."`
+	AppRoleMount string                    `json:"approle_mount,omitempty" doc:"Mount path of the AppRole auth method (default approle)."`
+}
+
+// Validate checks that the block reads at least one secret.
+func (c VaultConfig) Validate() error {
+	if len(c.Secrets) == 0 && len(c.Paths) == 0 {
+		return errors.New("must include a non-empty 'secrets' map or 'paths' list")
+	}
+	return nil
+}
+
+// vaultReference is a 'path#field' secret reference.
+type vaultReference string
+
+// Validate checks the form of the reference.
+func (r vaultReference) Validate() error {
+	if path, field, ok := r.split(); !ok || path == "" || field == "" {
+		return errors.New("must have the form 'path#field'")
+	}
+	return nil
+}
+
+// split returns the secret path and the field read from it.
+func (r vaultReference) split() (path string, field string, ok bool) {
+	return strings.Cut(strings.TrimSpace(string(r)), "#")
+}
+
+type vaultSecretConfig struct {
+	envVar string
+	path   string
+	field  string
+}
+
+type Vault struct {
+	secrets      []vaultSecretConfig
+	paths        []string
+	address      string
+	namespace    string
+	authMethod   string
+	appRoleMount string
+	cli          cliRunner
+	concurrency  int
+	enabled      bool
+}
+
+type vaultReadResponse struct {
+	Data map[string]interface{} `json:"data"`
+}
+
+type vaultLoginResponse struct {
+	Auth struct {
+		ClientToken string `json:"client_token"`
+	} `json:"auth"`
+}
+
+func init() {
+	registerSource("vault", func() Source { return NewVault() })
+	registerConfig("vault", VaultConfig{})
+	registerDiagnostics("vault", cliDiagnostics{
+		binary:      "vault",
+		versionArgs: []string{"version"},
+		authCommand: func(fullConfig map[string]interface{}) ([]string, []string) {
+			var config VaultConfig
+			decodeConfig(fullConfig, "vault", &config)
+			// AppRole logins happen at fetch time, so there is no token to check.
+			if config.AuthMethod == "approle" {
+				return nil, nil
+			}
+			var env []string
+			if address := strings.TrimSpace(config.Address); address != "" {
+				env = append(env, "VAULT_ADDR="+address)
+			}
+			if namespace := strings.TrimSpace(config.Namespace); namespace != "" {
+				env = append(env, "VAULT_NAMESPACE="+namespace)
+			}
+			return []string{"token", "lookup"}, env
+		},
+		installHint: "install the Vault CLI: https://developer.hashicorp.com/vault/install",
+		authHint:    "run 'vault login', set VAULT_TOKEN, or use auth_method 'approle'",
+	})
+}
+
+func NewVault() *Vault {
+	return &Vault{}
+}
+
+func (s *Vault) Init(fullConfig map[string]interface{}) error {
+	s.enabled = false
+	var config VaultConfig
+	if ok, err := decodeConfig(fullConfig, "vault", &config); !ok || err != nil {
+		return err
+	}
+
+	if _, err := lookupBinary("vault"); err != nil {
+		return fmt.Errorf("vault CLI not found: %w", err)
+	}
+
+	entries := make([]vaultSecretConfig, 0, len(config.Secrets))
+	for envVar, ref := range config.Secrets {
+		path, field, _ := ref.split()
+		entries = append(entries, vaultSecretConfig{envVar: envVar, path: path, field: field})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].envVar < entries[j].envVar })
+
+	appRoleMount := config.AppRoleMount
+	if appRoleMount == "" {
+		appRoleMount = "approle"
+	}
+
+	s.secrets = entries
+	s.paths = trimAll(config.Paths)
+	s.address = strings.TrimSpace(config.Address)
+	s.namespace = strings.TrimSpace(config.Namespace)
+	s.authMethod = config.AuthMethod
+	s.appRoleMount = strings.Trim(appRoleMount, "/")
+	s.cli = newCLIRunner(config.CommonConfig)
+	s.concurrency = concurrencyLimit(fullConfig)
+	s.enabled = true
+	return nil
+}
+
+func (s *Vault) GetAllSecrets(previous *secret.Secrets) (*secret.Secrets, error) {
+	env := buildCommandEnv(previous)
+	if s.address != "" {
+		env = append(env, "VAULT_ADDR="+s.address)
+	}
+	if s.namespace != "" {
+		env = append(env, "VAULT_NAMESPACE="+s.namespace)
+	}
+
+	env, err := s.authenticate(env)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read every distinct path once, whether it is referenced by individual
+	// fields or imported wholesale.
+	seen := make(map[string]bool)
+	var paths []string
+	for _, entry := range s.secrets {
+		if !seen[entry.path] {
+			seen[entry.path] = true
+			paths = append(paths, entry.path)
+		}
+	}
+	for _, path := range s.paths {
+		if !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+
+	fields := make([]map[string]string, len(paths))
+	err = forEachLimit(len(paths), s.concurrency, func(i int) error {
+		result, err := s.readPath(env, paths[i])
+		if err != nil {
+			return err
+		}
+		fields[i] = result
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	byPath := make(map[string]map[string]string, len(paths))
+	for i, path := range paths {
+		byPath[path] = fields[i]
+	}
+
+	results := secret.New()
+	for _, path := range s.paths {
+		for field, value := range byPath[path] {
+			results.Set(field, value, path+"#"+field)
+		}
+	}
+	for _, entry := range s.secrets {
+		value, ok := byPath[entry.path][entry.field]
+		if !ok {
+			return nil, fmt.Errorf("vault secret %s has no field %s", entry.path, entry.field)
+		}
+		results.Set(entry.envVar, value, entry.path+"#"+entry.field)
+	}
+
+	return results, nil
+}
+
+// authenticate returns env with a VAULT_TOKEN set when AppRole credentials
+// are to be used. Token auth (including the CLI's own token helper) needs
+// no extra work.
+func (s *Vault) authenticate(env []string) ([]string, error) {
+	roleID := envValue(env, "VAULT_ROLE_ID")
+	secretID := envValue(env, "VAULT_SECRET_ID")
+
+	useAppRole := s.authMethod == "approle" ||
+		(s.authMethod == "" && envValue(env, "VAULT_TOKEN") == "" && roleID != "" && secretID != "")
+	if !useAppRole {
+		return env, nil
+	}
+
+	if roleID == "" || secretID == "" {
+		return nil, fmt.Errorf("vault approle auth requires VAULT_ROLE_ID and VAULT_SECRET_ID")
+	}
+
+	// "secret_id=-" makes the CLI read the secret ID from stdin, keeping it
+	// out of the process list and of error messages. The login is not
+	// retried, since each attempt may use up a limited-use secret ID.
+	output, err := s.cli.runOnce("vault", env, secretID, "write", "-format=json",
+		fmt.Sprintf("auth/%s/login", s.appRoleMount), "role_id="+roleID, "secret_id=-")
+	if err != nil {
+		return nil, err
+	}
+
+	var payload vaultLoginResponse
+	if err := json.Unmarshal(output, &payload); err != nil {
+		return nil, fmt.Errorf("failed to parse vault approle login response: %w", err)
+	}
+	if payload.Auth.ClientToken == "" {
+		return nil, fmt.Errorf("vault approle login returned no client token")
+	}
+	redact.Register(payload.Auth.ClientToken)
+
+	return append(env, "VAULT_TOKEN="+payload.Auth.ClientToken), nil
+}
+
+// readPath reads a KV secret and returns its fields. The CLI resolves
+// whether the mount is KV v1 or v2; v2 responses nest the fields under
+// data.data next to data.metadata.
+func (s *Vault) readPath(env []string, path string) (map[string]string, error) {
+	output, err := s.cli.run("vault", env, "kv", "get", "-format=json", path)
+	if err != nil {
+		return nil, err
+	}
+
+	var payload vaultReadResponse
+	if err := json.Unmarshal(output, &payload); err != nil {
+		return nil, fmt.Errorf("failed to parse vault secret %s: %w", path, err)
+	}
+
+	data := payload.Data
+	nested, hasData := data["data"].(map[string]interface{})
+	_, hasMetadata := data["metadata"].(map[string]interface{})
+	if hasData && hasMetadata {
+		data = nested
+	}
+
+	fields := make(map[string]string, len(data))
+	for field, value := range data {
+		formatted, err := formatJSONValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode vault field %s of %s: %w", field, path, err)
+		}
+		fields[field] = formatted
+	}
+	return fields, nil
+}
+
+func (s *Vault) IsEnabled() bool {
+	return s.enabled
+}
@@ -0,0 +1,331 @@
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/napisani/secret_inject/internal/redact"
+	"github.com/napisani/secret_inject/internal/secret"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	encryptedFilePrefix  = "secret_inject."
+	encryptedFileSuffix  = ".enc"
+	encryptedFileVersion = 1
+	passphraseEnvVar     = "SECRET_INJECT_PASSPHRASE"
+
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+	scryptSalt   = 16
+
+	// The KDF parameters of a file are bounded so a tampered header cannot
+	// make reading the cache allocate gigabytes or spin for minutes.
+	maxScryptMemory = 1 << 30
+	maxScryptP      = 16
+)
+
+// encryptedEnvelope is the on-disk format of an encrypted cache file. The
+// KDF parameters are stored so they can be raised without breaking older
+// files.
+type encryptedEnvelope struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+type EncryptedFile struct {
+	dir       string
+	path      string
+	namespace string
+
+	passphraseOnce sync.Once
+	passphrase     string
+	passphraseErr  error
+	getPassphrase  func() (string, error)
+}
+
+// NewEncryptedFile returns storage that keeps the cache in an AES-256-GCM
+// encrypted file whose key is derived from a passphrase with scrypt. The
+// passphrase comes from the storage config, SECRET_INJECT_PASSPHRASE, or an
+// interactive prompt, in that order, and is only requested when the cache
+// is actually read or written.
+func NewEncryptedFile(storageConfig map[string]interface{}, namespace string) (*EncryptedFile, error) {
+	dir, _ := storageConfig["directory"].(string)
+	if strings.TrimSpace(dir) == "" {
+		cacheDir, err := os.UserCacheDir()
+		if err != nil {
+			return nil, fmt.Errorf("determining cache directory: %w", err)
+		}
+		dir = filepath.Join(cacheDir, "secret_inject")
+	}
+	dir, err := expandHome(strings.TrimSpace(dir))
+	if err != nil {
+		return nil, err
+	}
+
+	configPassphrase, _ := storageConfig["password"].(string)
+	getPassphrase := func() (string, error) {
+		if configPassphrase != "" {
+			return configPassphrase, nil
+		}
+		if env := os.Getenv(passphraseEnvVar); env != "" {
+			return env, nil
+		}
+		return getPasswordStdin("Enter secret_inject cache passphrase")
+	}
+
+	path := filepath.Join(dir, encryptedFilePrefix+namespace+encryptedFileSuffix)
+	slog.Debug("Using encrypted file storage", "location", path)
+	return &EncryptedFile{
+		dir:           dir,
+		path:          path,
+		namespace:     namespace,
+		getPassphrase: getPassphrase,
+	}, nil
+}
+
+func expandHome(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("expanding %s: %w", path, err)
+	}
+	return filepath.Join(home, strings.TrimPrefix(path, "~")), nil
+}
+
+func (s *EncryptedFile) loadPassphrase() (string, error) {
+	s.passphraseOnce.Do(func() {
+		s.passphrase, s.passphraseErr = s.getPassphrase()
+		redact.Register(s.passphrase)
+		if s.passphraseErr == nil && s.passphrase == "" {
+			s.passphraseErr = errors.New("encrypted-file storage requires a non-empty passphrase")
+		}
+	})
+	return s.passphrase, s.passphraseErr
+}
+
+func (s *EncryptedFile) HasCachedSecrets() bool {
+	_, err := os.Stat(s.path)
+	return err == nil
+}
+
+func (s *EncryptedFile) GetCachedSecrets() (*secret.Secrets, error) {
+	slog.Debug("Reading encrypted cached secrets", "path", s.path)
+
+	fileInfo, err := os.Stat(s.path)
+	if err != nil {
+		return nil, err
+	}
+	// Windows does not have POSIX permission bits; Mode().Perm() reports
+	// 0666 for any writable file there.
+	if perm := fileInfo.Mode().Perm(); runtime.GOOS != "windows" && perm&0o077 != 0 {
+		return nil, fmt.Errorf("cache file %s has insecure permissions %04o (expected 0600)", s.path, perm)
+	}
+
+	content, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var envelope encryptedEnvelope
+	if err := json.Unmarshal(content, &envelope); err != nil {
+		return nil, fmt.Errorf("parsing encrypted cache file: %w", err)
+	}
+	if envelope.Version != encryptedFileVersion || envelope.KDF != "scrypt" {
+		return nil, fmt.Errorf("unsupported encrypted cache format (version %d, kdf %q)", envelope.Version, envelope.KDF)
+	}
+	if err := checkKDFParams(envelope.N, envelope.R, envelope.P); err != nil {
+		return nil, fmt.Errorf("encrypted cache file %s: %w", s.path, err)
+	}
+
+	passphrase, err := s.loadPassphrase()
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(passphrase, envelope.Salt, envelope.N, envelope.R, envelope.P)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := gcm.Open(nil, envelope.Nonce, envelope.Ciphertext, []byte(s.namespace))
+	if err != nil {
+		return nil, errors.New("decrypting cached secrets failed (wrong passphrase or corrupted file)")
+	}
+
+	return secret.Deserialize(plaintext)
+}
+
+func (s *EncryptedFile) CacheSecrets(secrets *secret.Secrets) error {
+	passphrase, err := s.loadPassphrase()
+	if err != nil {
+		return err
+	}
+
+	serializedSecrets, err := secrets.Serialize()
+	if err != nil {
+		return err
+	}
+
+	salt := make([]byte, scryptSalt)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+
+	gcm, err := newGCM(passphrase, salt, scryptN, scryptR, scryptP)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	envelope := encryptedEnvelope{
+		Version:    encryptedFileVersion,
+		KDF:        "scrypt",
+		N:          scryptN,
+		R:          scryptR,
+		P:          scryptP,
+		Salt:       salt,
+		Nonce:      nonce,
+		Ciphertext: gcm.Seal(nil, nonce, serializedSecrets, []byte(s.namespace)),
+	}
+	content, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+
+	slog.Debug("Caching encrypted secrets", "path", s.path, "count", len(secrets.Entries))
+	return writeFileAtomic(s.dir, s.path, content)
+}
+
+// checkKDFParams rejects scrypt parameters that scrypt.Key would refuse or
+// that need more than maxScryptMemory (128*N*r bytes).
+func checkKDFParams(n, r, p int) error {
+	if n <= 1 || n&(n-1) != 0 || r <= 0 || p <= 0 || p > maxScryptP || n > maxScryptMemory/128/r {
+		return fmt.Errorf("unsupported scrypt parameters n=%d r=%d p=%d", n, r, p)
+	}
+	return nil
+}
+
+// newGCM derives the AES-256 key from the passphrase and returns the AEAD.
+// The namespace is used as additional data so a file cannot be swapped into
+// another namespace.
+func newGCM(passphrase string, salt []byte, n, r, p int) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, n, r, p, scryptKeyLen)
+	if err != nil {
+		return nil, fmt.Errorf("deriving cache key: %w", err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// writeFileAtomic writes content to a 0600 temp file in dir and renames it
+// over path, so readers never observe a partially written cache.
+func writeFileAtomic(dir string, path string, content []byte) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+func (s *EncryptedFile) CleanCachedSecrets() error {
+	err := os.Remove(s.path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	slog.Debug("Removed encrypted cached secrets", "path", s.path)
+	return nil
+}
+
+func (s *EncryptedFile) Info() Info {
+	return Info{Type: "encrypted-file", Location: s.path}
+}
+
+// cacheFiles returns the cache files in the storage directory. Only files
+// named like the ones this storage writes are matched, since the directory
+// may be shared with unrelated files.
+func (s *EncryptedFile) cacheFiles() ([]string, error) {
+	return filepath.Glob(filepath.Join(s.dir, encryptedFilePrefix+"*"+encryptedFileSuffix))
+}
+
+func (s *EncryptedFile) Namespaces() ([]string, error) {
+	matches, err := s.cacheFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	namespaces := make([]string, 0, len(matches))
+	for _, match := range matches {
+		name := strings.TrimPrefix(filepath.Base(match), encryptedFilePrefix)
+		namespaces = append(namespaces, strings.TrimSuffix(name, encryptedFileSuffix))
+	}
+	sort.Strings(namespaces)
+	return namespaces, nil
+}
+
+func (s *EncryptedFile) CleanAllCachedSecrets() error {
+	matches, err := s.cacheFiles()
+	if err != nil {
+		return err
+	}
+
+	for _, match := range matches {
+		if err := os.Remove(match); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		slog.Debug("Removed encrypted cached secrets", "path", match)
+	}
+	return nil
+}
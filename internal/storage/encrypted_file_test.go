@@ -0,0 +1,183 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/napisani/secret_inject/internal/secret"
+)
+
+func newTestEncryptedFile(t *testing.T, dir string, namespace string, passphrase string) *EncryptedFile {
+	t.Helper()
+	stor, err := NewEncryptedFile(map[string]interface{}{
+		"type":      "encrypted-file",
+		"directory": dir,
+		"password":  passphrase,
+	}, namespace)
+	if err != nil {
+		t.Fatalf("NewEncryptedFile failed: %v", err)
+	}
+	return stor
+}
+
+func TestEncryptedFileRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	stor := newTestEncryptedFile(t, dir, "aaaa", "correct horse")
+
+	secrets := secret.New()
+	secrets.Entries["API_KEY"] = "super-secret-value"
+	if err := stor.CacheSecrets(secrets); err != nil {
+		t.Fatalf("CacheSecrets failed: %v", err)
+	}
+
+	content, err := os.ReadFile(stor.path)
+	if err != nil {
+		t.Fatalf("reading cache file failed: %v", err)
+	}
+	if bytes.Contains(content, []byte("super-secret-value")) {
+		t.Fatalf("cache file contains the plaintext value")
+	}
+
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(stor.path)
+		if err != nil {
+			t.Fatalf("stat failed: %v", err)
+		}
+		if perm := info.Mode().Perm(); perm != 0o600 {
+			t.Fatalf("expected permissions 0600, got %o", perm)
+		}
+	}
+
+	loaded, err := newTestEncryptedFile(t, dir, "aaaa", "correct horse").GetCachedSecrets()
+	if err != nil {
+		t.Fatalf("GetCachedSecrets failed: %v", err)
+	}
+	if loaded.Entries["API_KEY"] != "super-secret-value" {
+		t.Fatalf("unexpected secrets: %v", loaded.Entries)
+	}
+}
+
+func TestEncryptedFileRejectsWrongPassphrase(t *testing.T) {
+	dir := t.TempDir()
+	if err := newTestEncryptedFile(t, dir, "aaaa", "correct horse").CacheSecrets(secret.New()); err != nil {
+		t.Fatalf("CacheSecrets failed: %v", err)
+	}
+
+	if _, err := newTestEncryptedFile(t, dir, "aaaa", "battery staple").GetCachedSecrets(); err == nil {
+		t.Fatalf("expected decryption to fail with the wrong passphrase")
+	}
+}
+
+func TestEncryptedFileIsBoundToNamespace(t *testing.T) {
+	dir := t.TempDir()
+	projA := newTestEncryptedFile(t, dir, "aaaa", "pass")
+	projB := newTestEncryptedFile(t, dir, "bbbb", "pass")
+
+	if err := projA.CacheSecrets(secret.New()); err != nil {
+		t.Fatalf("CacheSecrets failed: %v", err)
+	}
+	if projB.HasCachedSecrets() {
+		t.Fatalf("project B must not see project A's cache")
+	}
+
+	// A file copied into another namespace must not decrypt there.
+	content, err := os.ReadFile(projA.path)
+	if err != nil {
+		t.Fatalf("reading cache file failed: %v", err)
+	}
+	if err := os.WriteFile(projB.path, content, 0o600); err != nil {
+		t.Fatalf("writing cache file failed: %v", err)
+	}
+	if _, err := projB.GetCachedSecrets(); err == nil {
+		t.Fatalf("expected swapped cache file to be rejected")
+	}
+
+	unrelated := filepath.Join(dir, "backup.enc")
+	if err := os.WriteFile(unrelated, []byte("keep"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := projA.CleanAllCachedSecrets(); err != nil {
+		t.Fatalf("CleanAllCachedSecrets failed: %v", err)
+	}
+	if projA.HasCachedSecrets() || projB.HasCachedSecrets() {
+		t.Fatalf("expected every namespace to be removed")
+	}
+	if _, err := os.Stat(unrelated); err != nil {
+		t.Fatalf("expected unrelated files in the directory to be kept: %v", err)
+	}
+}
+
+func TestEncryptedFileRefusesInsecurePermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("POSIX permissions only")
+	}
+
+	dir := t.TempDir()
+	stor := newTestEncryptedFile(t, dir, "aaaa", "pass")
+	if err := stor.CacheSecrets(secret.New()); err != nil {
+		t.Fatalf("CacheSecrets failed: %v", err)
+	}
+	if err := os.Chmod(stor.path, 0o644); err != nil {
+		t.Fatalf("chmod failed: %v", err)
+	}
+
+	if _, err := stor.GetCachedSecrets(); err == nil {
+		t.Fatalf("expected world-readable cache file to be rejected")
+	}
+}
+
+func TestEncryptedFileRejectsExcessiveKDFParams(t *testing.T) {
+	dir := t.TempDir()
+	stor := newTestEncryptedFile(t, dir, "aaaa", "pass")
+	if err := stor.CacheSecrets(secret.New()); err != nil {
+		t.Fatalf("CacheSecrets failed: %v", err)
+	}
+	content, err := os.ReadFile(stor.path)
+	if err != nil {
+		t.Fatalf("reading cache file failed: %v", err)
+	}
+
+	for _, params := range []string{`"n":1073741824`, `"r":1048576`, `"p":1000000`, `"n":1000`} {
+		var envelope map[string]interface{}
+		if err := json.Unmarshal(content, &envelope); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal([]byte("{"+params+"}"), &envelope); err != nil {
+			t.Fatal(err)
+		}
+		tampered, _ := json.Marshal(envelope)
+		if err := os.WriteFile(stor.path, tampered, 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := stor.GetCachedSecrets(); err == nil || !strings.Contains(err.Error(), "unsupported scrypt parameters") {
+			t.Errorf("%s: expected the parameters to be rejected, got %v", params, err)
+		}
+	}
+}
+
+func TestEncryptedFileListsNamespaces(t *testing.T) {
+	dir := t.TempDir()
+	for _, namespace := range []string{"bbbb", "aaaa"} {
+		if err := newTestEncryptedFile(t, dir, namespace, "pw").CacheSecrets(secret.New()); err != nil {
+			t.Fatalf("CacheSecrets failed: %v", err)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "notes.enc"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	namespaces, err := newTestEncryptedFile(t, dir, "aaaa", "pw").Namespaces()
+	if err != nil {
+		t.Fatalf("Namespaces failed: %v", err)
+	}
+	if len(namespaces) != 2 || namespaces[0] != "aaaa" || namespaces[1] != "bbbb" {
+		t.Fatalf("unexpected namespaces %q", namespaces)
+	}
+}
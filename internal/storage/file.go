@@ -5,34 +5,49 @@ import (
 	"log/slog"
 	"os"
 	"path"
+	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/napisani/secret_inject/internal/secret"
 )
 
-const fileName = ".secret_inject.cache"
+const (
+	filePrefix = ".secret_inject"
+	fileSuffix = ".cache"
+)
 
 var tmpDir = os.TempDir()
-var fullFilePath = path.Join(tmpDir, fileName)
 
-type File struct{}
+type File struct {
+	fullFilePath string
+}
+
+// NewFile returns file storage for the given cache namespace. An empty
+// namespace uses the legacy un-namespaced cache file.
+func NewFile(namespace string) *File {
+	fileName := filePrefix + fileSuffix
+	if namespace != "" {
+		fileName = filePrefix + "." + namespace + fileSuffix
+	}
+	fullFilePath := path.Join(tmpDir, fileName)
 
-func NewFile() *File {
 	// Security warning only in debug mode to avoid polluting output
 	slog.Debug("Using file storage - secrets stored in PLAINTEXT",
 		"location", fullFilePath,
 		"warning", "Not secure for production use - consider using keyring storage")
-	return &File{}
+	return &File{fullFilePath: fullFilePath}
 }
 
 func (s *File) HasCachedSecrets() bool {
-	_, err := os.Stat(fullFilePath)
+	_, err := os.Stat(s.fullFilePath)
 	return !os.IsNotExist(err)
 }
 
 func (s *File) GetCachedSecrets() (*secret.Secrets, error) {
-	slog.Debug("Reading cached secrets", "path", fullFilePath)
+	slog.Debug("Reading cached secrets", "path", s.fullFilePath)
 
-	fileInfo, err := os.Stat(fullFilePath)
+	fileInfo, err := os.Stat(s.fullFilePath)
 	if os.IsNotExist(err) || fileInfo.Size() == 0 {
 		return nil, err
 	}
@@ -41,12 +56,12 @@ func (s *File) GetCachedSecrets() (*secret.Secrets, error) {
 	mode := fileInfo.Mode()
 	if mode.Perm() != 0600 {
 		slog.Debug("Cache file has insecure permissions",
-			"path", fullFilePath,
+			"path", s.fullFilePath,
 			"current", fmt.Sprintf("%04o", mode.Perm()),
 			"recommended", "0600")
 	}
 
-	content, err := os.ReadFile(fullFilePath)
+	content, err := os.ReadFile(s.fullFilePath)
 	if err != nil {
 		return nil, err
 	}
@@ -61,7 +76,7 @@ func (s *File) CacheSecrets(secrets *secret.Secrets) error {
 		return err
 	}
 
-	file, err := createFileIfNotExist(fullFilePath)
+	file, err := createFileIfNotExist(s.fullFilePath)
 	if err != nil {
 		return err
 	}
@@ -73,7 +88,7 @@ func (s *File) CacheSecrets(secrets *secret.Secrets) error {
 		slog.Debug("Failed to set secure file permissions", "error", err)
 	}
 
-	slog.Debug("Caching secrets", "path", fullFilePath, "count", len(secrets.Entries))
+	slog.Debug("Caching secrets", "path", s.fullFilePath, "count", len(secrets.Entries))
 	serializedSecrets, err := secrets.Serialize()
 	if err != nil {
 		return err
@@ -88,14 +103,48 @@ func (s *File) CacheSecrets(secrets *secret.Secrets) error {
 }
 
 func (s *File) CleanCachedSecrets() error {
-	err := os.Remove(fullFilePath)
+	err := os.Remove(s.fullFilePath)
 	if err != nil && !os.IsNotExist(err) {
 		return err
 	}
-	slog.Debug("Removed cached secrets", "path", fullFilePath)
+	slog.Debug("Removed cached secrets", "path", s.fullFilePath)
+	return nil
+}
+
+func (s *File) CleanAllCachedSecrets() error {
+	matches, err := filepath.Glob(filepath.Join(tmpDir, filePrefix+"*"+fileSuffix))
+	if err != nil {
+		return err
+	}
+
+	for _, match := range matches {
+		if err := os.Remove(match); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		slog.Debug("Removed cached secrets", "path", match)
+	}
 	return nil
 }
 
+func (s *File) Info() Info {
+	return Info{Type: "file", Location: s.fullFilePath}
+}
+
+func (s *File) Namespaces() ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(tmpDir, filePrefix+"*"+fileSuffix))
+	if err != nil {
+		return nil, err
+	}
+
+	namespaces := make([]string, 0, len(matches))
+	for _, match := range matches {
+		name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(match), filePrefix), fileSuffix)
+		namespaces = append(namespaces, strings.TrimPrefix(name, "."))
+	}
+	sort.Strings(namespaces)
+	return namespaces, nil
+}
+
 // Helper functions
 func mkdirRecursive(path string) error {
 	slog.Debug("Creating directory (if does not exist)", "path", path)
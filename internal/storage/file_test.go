@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/napisani/secret_inject/internal/secret"
+)
+
+func withTempDir(t *testing.T) {
+	t.Helper()
+	original := tmpDir
+	tmpDir = t.TempDir()
+	t.Cleanup(func() { tmpDir = original })
+}
+
+func TestFileNamespacesDoNotShareCache(t *testing.T) {
+	withTempDir(t)
+
+	projA := NewFile("aaaa")
+	projB := NewFile("bbbb")
+
+	secrets := secret.New()
+	secrets.Entries["API_KEY"] = "project-a"
+	if err := projA.CacheSecrets(secrets); err != nil {
+		t.Fatalf("CacheSecrets failed: %v", err)
+	}
+
+	if !projA.HasCachedSecrets() {
+		t.Fatalf("expected project A to have cached secrets")
+	}
+	if projB.HasCachedSecrets() {
+		t.Fatalf("project B must not see project A's cache")
+	}
+}
+
+func TestFileCleanOnlyRemovesOwnNamespace(t *testing.T) {
+	withTempDir(t)
+
+	projA := NewFile("aaaa")
+	projB := NewFile("bbbb")
+	legacy := NewFile("")
+
+	for _, stor := range []*File{projA, projB, legacy} {
+		if err := stor.CacheSecrets(secret.New()); err != nil {
+			t.Fatalf("CacheSecrets failed: %v", err)
+		}
+	}
+
+	if err := projA.CleanCachedSecrets(); err != nil {
+		t.Fatalf("CleanCachedSecrets failed: %v", err)
+	}
+	if projA.HasCachedSecrets() {
+		t.Fatalf("expected project A cache to be removed")
+	}
+	if !projB.HasCachedSecrets() {
+		t.Fatalf("expected project B cache to survive")
+	}
+
+	if err := projA.CleanAllCachedSecrets(); err != nil {
+		t.Fatalf("CleanAllCachedSecrets failed: %v", err)
+	}
+	if projB.HasCachedSecrets() || legacy.HasCachedSecrets() {
+		t.Fatalf("expected every namespace to be removed")
+	}
+}
+
+func TestFileListsNamespaces(t *testing.T) {
+	withTempDir(t)
+
+	for _, namespace := range []string{"bbbb", "", "aaaa"} {
+		if err := NewFile(namespace).CacheSecrets(secret.New()); err != nil {
+			t.Fatalf("CacheSecrets failed: %v", err)
+		}
+	}
+
+	namespaces, err := NewFile("aaaa").Namespaces()
+	if err != nil {
+		t.Fatalf("Namespaces failed: %v", err)
+	}
+	if strings.Join(namespaces, ",") != ",aaaa,bbbb" {
+		t.Fatalf("unexpected namespaces %q", namespaces)
+	}
+
+	if info := NewFile("aaaa").Info(); info.Type != "file" || filepath.Base(info.Location) != ".secret_inject.aaaa.cache" {
+		t.Fatalf("unexpected info %+v", info)
+	}
+}
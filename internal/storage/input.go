@@ -7,12 +7,14 @@ import (
 	"golang.org/x/term"
 )
 
+// getPasswordStdin reads a password from the terminal on stdin. The prompt
+// goes to stderr, since stdout may be captured, as in eval "$(secret_inject)".
 func getPasswordStdin(prompt string) (string, error) {
-	fmt.Printf("%s: ", prompt)
+	fmt.Fprintf(os.Stderr, "%s: ", prompt)
 	b, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
 	if err != nil {
 		return "", err
 	}
-	fmt.Println()
 	return string(b), nil
 }
@@ -5,18 +5,24 @@ import (
 	"log/slog"
 	"os"
 	"path"
+	"sort"
+	"strings"
 
 	keyring "github.com/99designs/keyring"
 	"github.com/napisani/secret_inject/internal/secret"
 )
 
-const key = "secret_inject"
+const keyPrefix = "secret_inject"
 
 type Keyring struct {
 	keyring keyring.Keyring
+	key     string
+	backend keyring.BackendType
 }
 
-func NewKeyring(storageConfig map[string]interface{}) (*Keyring, error) {
+// NewKeyring opens the keyring and scopes the cache to the given namespace.
+// An empty namespace uses the legacy un-namespaced key.
+func NewKeyring(storageConfig map[string]interface{}, namespace string) (*Keyring, error) {
 	name := "secret_inject"
 
 	allowedBackends := []keyring.BackendType{}
@@ -66,19 +72,79 @@ func NewKeyring(storageConfig map[string]interface{}) (*Keyring, error) {
 	}
 
 	slog.Debug("Keyring config", "service", name)
-	kr, err := keyring.Open(keyringConfig)
+	kr, backend, err := openKeyring(keyringConfig)
 
 	if err != nil {
 		return nil, err
 	}
+	slog.Debug("Opened keyring", "backend", backend)
+
+	key := keyPrefix
+	if namespace != "" {
+		key = keyPrefix + "-" + namespace
+	}
 
 	return &Keyring{
 		keyring: kr,
+		key:     key,
+		backend: backend,
 	}, nil
 }
 
+// openKeyring opens the first backend that works, like keyring.Open, but
+// also reports which backend that was.
+func openKeyring(cfg keyring.Config) (keyring.Keyring, keyring.BackendType, error) {
+	backends := cfg.AllowedBackends
+	if backends == nil {
+		backends = keyring.AvailableBackends()
+	}
+
+	for _, backend := range backends {
+		single := cfg
+		single.AllowedBackends = []keyring.BackendType{backend}
+		kr, err := keyring.Open(single)
+		if err == nil {
+			return kr, backend, nil
+		}
+		slog.Debug("Keyring backend unavailable", "backend", backend, "error", err)
+	}
+	return nil, keyring.InvalidBackend, keyring.ErrNoAvailImpl
+}
+
+func (s *Keyring) Info() Info {
+	available := keyring.AvailableBackends()
+	names := make([]string, 0, len(available))
+	for _, backend := range available {
+		names = append(names, string(backend))
+	}
+	return Info{
+		Type:              "keyring",
+		Location:          s.key,
+		Backend:           string(s.backend),
+		AvailableBackends: names,
+	}
+}
+
+func (s *Keyring) Namespaces() ([]string, error) {
+	keys, err := s.keyring.Keys()
+	if err != nil {
+		return nil, err
+	}
+
+	var namespaces []string
+	for _, key := range keys {
+		if key == keyPrefix {
+			namespaces = append(namespaces, "")
+		} else if namespace, ok := strings.CutPrefix(key, keyPrefix+"-"); ok {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	sort.Strings(namespaces)
+	return namespaces, nil
+}
+
 func (s *Keyring) HasCachedSecrets() bool {
-	value, err := s.keyring.Get(key)
+	value, err := s.keyring.Get(s.key)
 	if err != nil {
 		slog.Debug("Error getting keys", "error", err)
 		return false
@@ -88,7 +154,7 @@ func (s *Keyring) HasCachedSecrets() bool {
 
 func (s *Keyring) GetCachedSecrets() (*secret.Secrets, error) {
 	slog.Debug("Reading cached secrets from keyring")
-	value, err := s.keyring.Get(key)
+	value, err := s.keyring.Get(s.key)
 	if err != nil {
 		slog.Debug("Error getting key", "error", err)
 		return nil, err
@@ -109,7 +175,7 @@ func (s *Keyring) CacheSecrets(secrets *secret.Secrets) error {
 		return err
 	}
 
-	err = s.keyring.Set(keyring.Item{Key: key, Data: serializedSecrets})
+	err = s.keyring.Set(keyring.Item{Key: s.key, Data: serializedSecrets})
 	if err != nil {
 		return err
 	}
@@ -124,6 +190,24 @@ func (s *Keyring) CleanCachedSecrets() error {
 		return nil
 	}
 
-	slog.Debug("Removing cached secrets from keyring", "key", key)
-	return s.keyring.Remove(key)
+	slog.Debug("Removing cached secrets from keyring", "key", s.key)
+	return s.keyring.Remove(s.key)
+}
+
+func (s *Keyring) CleanAllCachedSecrets() error {
+	keys, err := s.keyring.Keys()
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if key != keyPrefix && !strings.HasPrefix(key, keyPrefix+"-") {
+			continue
+		}
+		slog.Debug("Removing cached secrets from keyring", "key", key)
+		if err := s.keyring.Remove(key); err != nil {
+			return err
+		}
+	}
+	return nil
 }
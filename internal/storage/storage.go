@@ -4,6 +4,7 @@ import (
 	"errors"
 	"log/slog"
 
+	"github.com/napisani/secret_inject/internal/redact"
 	"github.com/napisani/secret_inject/internal/secret"
 )
 
@@ -12,9 +13,38 @@ type Storage interface {
 	GetCachedSecrets() (*secret.Secrets, error)
 	CacheSecrets(secrets *secret.Secrets) error
 	CleanCachedSecrets() error
+	// CleanAllCachedSecrets removes the cached secrets of every namespace.
+	CleanAllCachedSecrets() error
 }
 
-func Get(storageConfig map[string]interface{}) (Storage, error) {
+// Info describes where a storage keeps the cache.
+type Info struct {
+	Type     string
+	Location string
+	// Backend is the keyring backend in use and AvailableBackends the ones
+	// compiled in for this platform; both are empty for file storages.
+	Backend           string
+	AvailableBackends []string
+}
+
+// Inspector is implemented by storages that can describe themselves and
+// list the namespaces they hold cached secrets for. The legacy
+// un-namespaced cache is listed as "".
+type Inspector interface {
+	Info() Info
+	Namespaces() ([]string, error)
+}
+
+// Config is the "storage" block of the config file.
+type Config struct {
+	Type            string   `json:"type" schema:"required,enum=keyring|file|encrypted-file" doc:"Where the cache is kept: keyring (recommended), file (development only) or encrypted-file."`
+	AllowedBackends []string `json:"allowed_backends,omitempty" schema:"enum=keychain|wincred|secret-service|kwallet|keyctl|pass|file" doc:"Keyring backends that may be used, in order of preference."`
+	Password        string   `json:"password,omitempty" doc:"Password of the keyring file backend or passphrase of the encrypted file; prompted for when unset."`
+	Directory       string   `json:"directory,omitempty" doc:"Directory of the encrypted file cache (default: the user cache directory)."`
+}
+
+// Get opens the configured storage with the cache scoped to namespace.
+func Get(storageConfig map[string]interface{}, namespace string) (Storage, error) {
 	if storageConfig == nil {
 		return nil, errors.New("no storage configuration found in config file")
 	}
@@ -23,15 +53,21 @@ func Get(storageConfig map[string]interface{}) (Storage, error) {
 	if !ok {
 		return nil, errors.New("storage 'type' field is required and must be a string")
 	}
+	if password, _ := storageConfig["password"].(string); password != "" {
+		redact.Register(password)
+	}
 
 	switch storageType {
 	case "keyring":
 		slog.Debug("Using keyring storage")
-		return NewKeyring(storageConfig)
+		return NewKeyring(storageConfig, namespace)
 	case "file":
 		slog.Debug("Using file storage")
-		return NewFile(), nil
+		return NewFile(namespace), nil
+	case "encrypted-file":
+		slog.Debug("Using encrypted file storage")
+		return NewEncryptedFile(storageConfig, namespace)
 	default:
-		return nil, errors.New("unknown storage type: must be 'keyring' or 'file'")
+		return nil, errors.New("unknown storage type: must be 'keyring', 'file' or 'encrypted-file'")
 	}
 }
@@ -0,0 +1,190 @@
+package transform
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/napisani/secret_inject/internal/secret"
+)
+
+// Policies for secret names that are not valid environment variable names.
+const (
+	InvalidNamesSanitize = "sanitize"
+	InvalidNamesError    = "error"
+)
+
+// Config is the serialized form of a set of name transformation rules.
+// Rules run in a fixed order: an explicit rename replaces the name outright;
+// otherwise strip_prefix, replace, uppercase and add_prefix are applied in
+// that order.
+type Config struct {
+	Rename       map[string]string `json:"rename,omitempty" doc:"Secret names mapped to the name to use instead."`
+	StripPrefix  string            `json:"strip_prefix,omitempty" doc:"Prefix removed from secret names."`
+	Replace      map[string]string `json:"replace,omitempty" doc:"Substrings of secret names mapped to their replacement."`
+	Uppercase    bool              `json:"uppercase,omitempty" doc:"Convert secret names to upper case."`
+	AddPrefix    string            `json:"add_prefix,omitempty" doc:"Prefix added to secret names."`
+	InvalidNames string            `json:"invalid_names,omitempty" schema:"enum=sanitize|error" doc:"What to do with names that are not valid environment variable names: sanitize or error."`
+}
+
+// Rules renames secrets as they pass through the pipeline.
+type Rules struct {
+	config   Config
+	replacer *strings.Replacer
+}
+
+// Compile validates the config.
+func (c Config) Compile() (*Rules, error) {
+	switch c.InvalidNames {
+	case "", InvalidNamesSanitize, InvalidNamesError:
+	default:
+		return nil, fmt.Errorf("invalid_names must be '%s' or '%s'", InvalidNamesSanitize, InvalidNamesError)
+	}
+
+	replaces := make([]string, 0, len(c.Replace))
+	for from := range c.Replace {
+		if from == "" {
+			return nil, fmt.Errorf("replace keys must be non-empty")
+		}
+		replaces = append(replaces, from)
+	}
+	// Prefer longer patterns where several match at the same position so
+	// overlapping rules behave predictably.
+	sort.Slice(replaces, func(i, j int) bool {
+		if len(replaces[i]) != len(replaces[j]) {
+			return len(replaces[i]) > len(replaces[j])
+		}
+		return replaces[i] < replaces[j]
+	})
+	var replacer *strings.Replacer
+	if len(replaces) > 0 {
+		pairs := make([]string, 0, 2*len(replaces))
+		for _, from := range replaces {
+			pairs = append(pairs, from, c.Replace[from])
+		}
+		replacer = strings.NewReplacer(pairs...)
+	}
+
+	for from, to := range c.Rename {
+		if from == "" || to == "" {
+			return nil, fmt.Errorf("rename entries must map non-empty names")
+		}
+	}
+
+	return &Rules{config: c, replacer: replacer}, nil
+}
+
+// Validate checks the config without building the rules.
+func (c Config) Validate() error {
+	_, err := c.Compile()
+	return err
+}
+
+// FromRaw compiles rules from a decoded config block such as a source's
+// "transform" entry. A nil block yields empty rules.
+func FromRaw(raw interface{}) (*Rules, error) {
+	if raw == nil {
+		return &Rules{}, nil
+	}
+
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(encoded, &cfg); err != nil {
+		return nil, fmt.Errorf("transform must be an object: %w", err)
+	}
+	return cfg.Compile()
+}
+
+// InvalidNames returns the configured policy for invalid names, or "" when
+// the rules leave it to a broader scope.
+func (r *Rules) InvalidNames() string {
+	if r == nil {
+		return ""
+	}
+	return r.config.InvalidNames
+}
+
+// Name returns the transformed form of name.
+func (r *Rules) Name(name string) string {
+	if r == nil {
+		return name
+	}
+
+	if renamed, ok := r.config.Rename[name]; ok {
+		return renamed
+	}
+
+	name = strings.TrimPrefix(name, r.config.StripPrefix)
+	if r.replacer != nil {
+		// A single pass, so the output of one rule is never rewritten by
+		// another.
+		name = r.replacer.Replace(name)
+	}
+	if r.config.Uppercase {
+		name = strings.ToUpper(name)
+	}
+	return r.config.AddPrefix + name
+}
+
+// Apply renames every secret, failing if two secrets end up with the same
+// name.
+func (r *Rules) Apply(secrets *secret.Secrets) (*secret.Secrets, error) {
+	return secrets.RenameKeys(func(key string) (string, error) {
+		return r.Name(key), nil
+	})
+}
+
+// ValidName reports whether name can be used as an environment variable in
+// every supported output format.
+func ValidName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case r == '_', r >= 'A' && r <= 'Z', r >= 'a' && r <= 'z':
+		case r >= '0' && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Sanitize turns name into a valid environment variable name by replacing
+// every other character with '_' and prefixing names that start with a
+// digit.
+func Sanitize(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if (r >= 'A' && r <= 'Z') || (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '_' {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('_')
+		}
+	}
+	sanitized := b.String()
+	if sanitized == "" || (sanitized[0] >= '0' && sanitized[0] <= '9') {
+		sanitized = "_" + sanitized
+	}
+	return sanitized
+}
+
+// EnforceValidNames rejects or sanitizes invalid names according to policy
+// (sanitizing when policy is empty).
+func EnforceValidNames(secrets *secret.Secrets, policy string) (*secret.Secrets, error) {
+	return secrets.RenameKeys(func(key string) (string, error) {
+		if ValidName(key) {
+			return key, nil
+		}
+		if policy == InvalidNamesError {
+			return "", fmt.Errorf("%q is not a valid environment variable name", key)
+		}
+		return Sanitize(key), nil
+	})
+}
@@ -0,0 +1,112 @@
+package transform
+
+import (
+	"testing"
+
+	"github.com/napisani/secret_inject/internal/secret"
+)
+
+func TestRulesName(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		input  string
+		want   string
+	}{
+		{"no rules", Config{}, "api-token", "api-token"},
+		{"replace and uppercase", Config{Replace: map[string]string{"-": "_"}, Uppercase: true}, "api-token", "API_TOKEN"},
+		{"strip prefix", Config{StripPrefix: "PROD_"}, "PROD_DB_URL", "DB_URL"},
+		{"add prefix", Config{AddPrefix: "APP_"}, "DB_URL", "APP_DB_URL"},
+		{"strip then add", Config{StripPrefix: "PROD_", AddPrefix: "APP_"}, "PROD_DB_URL", "APP_DB_URL"},
+		{"rename wins", Config{Rename: map[string]string{"db-url": "DATABASE_URL"}, Uppercase: true}, "db-url", "DATABASE_URL"},
+		{"longer replace first", Config{Replace: map[string]string{"-": "_", "--": "__"}}, "a--b-c", "a__b_c"},
+		{"replace does not chain", Config{Replace: map[string]string{"-": "_", "_": "."}}, "a-b_c", "a_b.c"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := tt.config.Compile()
+			if err != nil {
+				t.Fatalf("Compile failed: %v", err)
+			}
+			if got := rules.Name(tt.input); got != tt.want {
+				t.Errorf("Name(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyDetectsCollisions(t *testing.T) {
+	rules, err := Config{StripPrefix: "DEV_"}.Compile()
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	secrets := secret.New()
+	secrets.Entries["DEV_API_KEY"] = "dev"
+	secrets.Entries["API_KEY"] = "prod"
+
+	if _, err := rules.Apply(secrets); err == nil {
+		t.Fatalf("expected collision error")
+	}
+}
+
+func TestValidNameAndSanitize(t *testing.T) {
+	tests := []struct {
+		input     string
+		valid     bool
+		sanitized string
+	}{
+		{"API_TOKEN", true, "API_TOKEN"},
+		{"_private", true, "_private"},
+		{"api-token", false, "api_token"},
+		{"1PASSWORD", false, "_1PASSWORD"},
+		{"with space", false, "with_space"},
+		{"", false, "_"},
+	}
+
+	for _, tt := range tests {
+		if got := ValidName(tt.input); got != tt.valid {
+			t.Errorf("ValidName(%q) = %v, want %v", tt.input, got, tt.valid)
+		}
+		if got := Sanitize(tt.input); got != tt.sanitized {
+			t.Errorf("Sanitize(%q) = %q, want %q", tt.input, got, tt.sanitized)
+		}
+	}
+}
+
+func TestEnforceValidNames(t *testing.T) {
+	secrets := secret.New()
+	secrets.Entries["api-token"] = "token"
+	secrets.Entries["DB_URL"] = "url"
+
+	sanitized, err := EnforceValidNames(secrets, "")
+	if err != nil {
+		t.Fatalf("EnforceValidNames failed: %v", err)
+	}
+	if sanitized.Entries["api_token"] != "token" || sanitized.Entries["DB_URL"] != "url" {
+		t.Fatalf("unexpected sanitized secrets: %v", sanitized.Entries)
+	}
+
+	if _, err := EnforceValidNames(secrets, InvalidNamesError); err == nil {
+		t.Fatalf("expected error for invalid name")
+	}
+
+	secrets.Entries["api_token"] = "other"
+	if _, err := EnforceValidNames(secrets, InvalidNamesSanitize); err == nil {
+		t.Fatalf("expected collision error when sanitizing onto an existing name")
+	}
+}
+
+func TestCompileRejectsInvalidConfig(t *testing.T) {
+	cases := []Config{
+		{InvalidNames: "ignore"},
+		{Replace: map[string]string{"": "_"}},
+		{Rename: map[string]string{"OLD": ""}},
+	}
+	for _, cfg := range cases {
+		if _, err := cfg.Compile(); err == nil {
+			t.Errorf("expected error for %+v", cfg)
+		}
+	}
+}